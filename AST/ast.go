@@ -6,7 +6,16 @@ import (
 )
 
 type Ast struct {
-	Root BlockNode
+	Root     BlockNode
+	Comments []Comment // Only filled in when the parser keeps trivia
+	Tokens   []Pos     // Start of every token in source order, only when the parser keeps trivia
+	Done     *Pos      // Where an explicit done ended the program, if one did
+}
+
+// Comment is a |comment| or a directive such as #if NAME, with its delimiters
+type Comment struct {
+	Span
+	Text string
 }
 
 func (a *Ast) Display() {
@@ -100,6 +109,20 @@ func displayNode(node Node, indent int) {
 		}
 	case N_BREAKPOINT:
 		fmt.Println(indentation + "BreakpointNode")
+	case N_ASM:
+		n := node.(*AsmNode)
+		fmt.Println(indentation + "AsmNode")
+		for _, binding := range n.Bindings {
+			fmt.Println(indentation + "  Binding: " + binding.Name)
+		}
+		var code string
+		for _, part := range n.Parts {
+			if part.Ref.Name != "" {
+				code += "{" + part.Ref.Name + "}"
+			}
+			code += part.Code
+		}
+		fmt.Println(indentation + "  Code: " + code)
 	default:
 		fmt.Println(indentation + "Unknown Node")
 	}
@@ -17,75 +17,128 @@ const (
 	N_MACRO
 	N_MACRO_CALL
 	N_BREAKPOINT
+	N_ASM
 )
 
 type Node interface {
 	Type() NodeType
+	Position() Span
 }
 
+// Pos is a 1-based line and column in the source
+type Pos struct {
+	Line int
+	Col  int
+}
+
+// Span is the source range a statement was parsed from; for blocks such as
+// if and while it covers the header only
+type Span struct {
+	Start Pos
+	End   Pos
+}
+
+func (s Span) Position() Span {
+	return s
+}
+
+// BlockNode is a sequence of statements. The body of an if, while or macro
+// spans from the end of its header to the start of its closing keyword.
 type BlockNode struct {
+	Span
 	Nodes []Node
+	Call  *MacroCallNode // The call this block is the expansion of, if any
 }
 
 type AssignNode struct {
+	Span
 	Left  IdentToken
 	Right Token
 }
 
 type AddNode struct {
+	Span
 	Left  IdentToken
 	Right Token
 }
 
 type SubNode struct {
+	Span
 	Left  IdentToken
 	Right Token
 }
 
 type IfNode struct {
+	Span
 	Id    IdentToken
 	Block BlockNode
 }
 
 type IfNotNode struct {
+	Span
 	Id    IdentToken
 	Block BlockNode
 }
 
 type WhileNode struct {
+	Span
 	Id    IdentToken
 	Block BlockNode
 }
 
 type WhileNotNode struct {
+	Span
 	Id    IdentToken
 	Block BlockNode
 }
 
 type WriteNode struct {
+	Span
 	Value Token
 }
 
 type ReadNode struct {
+	Span
 	Value IdentToken
 }
 
 type FreeNode struct {
+	Span
 	Value IdentToken
 }
 
 type MacroNode struct {
+	Span
 	Name   IdentToken
 	Params []IdentToken
 	Block  BlockNode
 }
 
 type MacroCallNode struct {
+	Span
 	Name IdentToken
-	Args map[IdentToken]Token
+	Args map[string]Token // Keyed by parameter name
 }
 
-type BreakpointNode struct{}
+type BreakpointNode struct {
+	Span
+}
+
+// AsmNode holds a raw Brainf*** snippet. The snippet starts with the pointer
+// on the first binding; each part moves to its Ref (if any) before running Code.
+// The snippet sees the bindings on consecutive cells in declared order, so >
+// and < move from one binding to the next whatever cells the variables have.
+type AsmNode struct {
+	Span
+	Bindings []IdentToken
+	Parts    []AsmPart
+	Raw      string // The body as written, between define and endasm
+}
+
+type AsmPart struct {
+	Ref  IdentToken
+	Code string
+}
 
 func (n *BlockNode) Type() NodeType {
 	return N_BLOCK
@@ -142,3 +195,7 @@ func (n *MacroCallNode) Type() NodeType {
 func (n *BreakpointNode) Type() NodeType {
 	return N_BREAKPOINT
 }
+
+func (n *AsmNode) Type() NodeType {
+	return N_ASM
+}
@@ -13,10 +13,13 @@ type Token interface {
 
 type IdentToken struct {
 	Name string
+	Pos  Pos
 }
 
 type LitToken struct {
 	Value string
+	Text  string // As written in the source, such as 'H' for 72
+	Pos   Pos
 }
 
 func (t *IdentToken) Type() TokenType {
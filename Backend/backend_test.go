@@ -0,0 +1,94 @@
+package Backend_test
+
+import (
+	"braining/Compiler"
+	"braining/IR"
+	"braining/Logging"
+	"braining/Parser"
+	"braining/Tester"
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var targets = []*IR.Target{
+	{CellBits: 8, Wrapping: true},
+	{CellBits: 16, Wrapping: true},
+	{CellBits: 32, Wrapping: true},
+}
+
+// program is a program under testdata with the output it has when compiled
+// to Brainf*** and run on the interpreter
+type program struct {
+	name  string
+	src   string
+	input []byte
+	want  []byte
+}
+
+func corpus(t *testing.T, target *IR.Target) []program {
+	t.Helper()
+	cases, err := Tester.Corpus([]string{"../testdata"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := []program{}
+	for _, r := range Tester.Run(cases, &Tester.Options{Target: target}) {
+		if r.Err != nil {
+			t.Fatalf("%s: %v", r.Case, r.Err)
+		}
+		src, err := os.ReadFile(r.Program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := strings.TrimSuffix(filepath.Base(r.Program), ".br")
+		res = append(res, program{name: name, src: string(src), input: r.Input, want: r.Output})
+	}
+	return res
+}
+
+// build compiles a program into the file at path through a backend
+func build(t *testing.T, p program, target *IR.Target, path string, backend func(io.Writer) IR.Sink) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var compileErr error
+	err = Logging.Catch(func() {
+		a := Parser.NewParser(p.src, Logging.NewRecoverableLogger("braining_parser")).Parse()
+		c := Compiler.NewCompiler(a, target, Logging.NewRecoverableLogger("braining_compiler"))
+		compileErr = c.CompileIR(backend(f))
+	})
+	if err == nil {
+		err = compileErr
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		t.Fatalf("%s: %v", p.name, err)
+	}
+}
+
+// check runs an executable on the input of p and compares what it writes
+// with the Brainf*** path
+func check(t *testing.T, p program, path string) {
+	t.Helper()
+	cmd := exec.Command(path)
+	cmd.Stdin = bytes.NewReader(p.input)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Errorf("%s: %v", p.name, err)
+		return
+	}
+	if !bytes.Equal(out, p.want) {
+		t.Errorf("%s: wrong output\n%s", p.name, Tester.Diff(p.want, out))
+	}
+}
@@ -0,0 +1,229 @@
+package Backend
+
+import (
+	"braining/IR"
+	"bufio"
+	"io"
+	"strings"
+)
+
+const (
+	BF_INC        = "+"
+	BF_DEC        = "-"
+	BF_PTR_L      = "<"
+	BF_PTR_R      = ">"
+	BF_WRITE      = "."
+	BF_READ       = ","
+	BF_OPEN       = "["
+	BF_CLOSE      = "]"
+	BF_BREAKPOINT = "!"
+)
+
+// BFEmitter lowers IR to Brainf***, or to another dialect of it, tracking
+// where the pointer is so each op only moves as far as it needs to
+type BFEmitter struct {
+	w       *bufio.Writer
+	dialect *Dialect
+	pointer int
+	offset  int    // Bytes written so far
+	Marks   []Mark // Where each mark op landed in the output
+
+	// Comment switches to formatted output when set: the code of each
+	// statement starts on a new line under the comment returned for its mark,
+	// and lines are indented by loop depth
+	Comment func(id int) string
+
+	pending   []int // Marks waiting for the first token of their statement
+	depth     int
+	lineStart bool
+	end       int
+
+	comments *strings.Replacer // Escapes code out of comments
+
+	instructions int // Tokens written so far
+	moves        int // Pointer moves written so far
+}
+
+// Mark records that the code for statement Id starts at byte Offset of the
+// output. The code before it ends at Before, which is earlier than Offset when
+// a comment or separator sits in between. Instructions and Moves count the
+// tokens and pointer moves written before it.
+type Mark struct {
+	Id           int
+	Offset       int
+	Before       int
+	Instructions int
+	Moves        int
+}
+
+// NewBFEmitter writes in the given dialect, or plain Brainf*** if it is nil
+func NewBFEmitter(w io.Writer, dialect *Dialect) *BFEmitter {
+	if dialect == nil {
+		dialect = BFDialect
+	}
+	return &BFEmitter{w: bufio.NewWriter(w), dialect: dialect, comments: commentReplacer(dialect), lineStart: true}
+}
+
+func (e *BFEmitter) write(s string) {
+	e.offset += len(s)
+	e.w.WriteString(s)
+}
+
+// put writes a token n times
+func (e *BFEmitter) put(token string, n int) {
+	if token == "" || n <= 0 {
+		return
+	}
+	e.flushMarks()
+	for range n {
+		if !e.lineStart {
+			e.write(e.dialect.Separator)
+		}
+		e.lineStart = false
+		e.write(token)
+	}
+	e.instructions += n
+}
+
+func (e *BFEmitter) newLine() {
+	if e.offset > 0 {
+		e.write("\n")
+	}
+	e.write(strings.Repeat("  ", e.depth))
+	e.lineStart = true
+}
+
+// flushMarks records the marks seen since the last token, now that their
+// code is about to start, and writes their comments in formatted mode
+func (e *BFEmitter) flushMarks() {
+	if len(e.pending) == 0 {
+		return
+	}
+	before := e.offset
+	if e.Comment != nil {
+		for _, id := range e.pending {
+			e.newLine()
+			e.write(e.comments.Replace(e.Comment(id)))
+		}
+		e.newLine()
+	}
+
+	offset := e.offset
+	if !e.lineStart {
+		offset += len(e.dialect.Separator)
+	}
+	for _, id := range e.pending {
+		e.Marks = append(e.Marks, Mark{Id: id, Offset: offset, Before: before, Instructions: e.instructions, Moves: e.moves})
+	}
+	e.pending = e.pending[:0]
+}
+
+// commentReplacer swaps the characters of Brainf*** commands, and of the tokens
+// of a dialect, for look-alikes that interpreters ignore. Printable ASCII has a
+// fullwidth form; any other character becomes a space, which no token holds.
+func commentReplacer(d *Dialect) *strings.Replacer {
+	chars := "+-<>.,[]!#" + d.Inc + d.Dec + d.Left + d.Right + d.Write + d.Read + d.Open + d.Close + d.Breakpoint
+	pairs := []string{"\n", " ", "\r", " "}
+	seen := make(map[rune]bool)
+	for _, r := range chars {
+		if seen[r] {
+			continue
+		}
+		seen[r] = true
+		if r > ' ' && r <= '~' {
+			pairs = append(pairs, string(r), string(r-'!'+'\uFF01'))
+		} else {
+			pairs = append(pairs, string(r), " ")
+		}
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+func (e *BFEmitter) moveTo(loc int) {
+	if e.pointer > loc {
+		e.put(e.dialect.Left, e.pointer-loc)
+	} else {
+		e.put(e.dialect.Right, loc-e.pointer)
+	}
+	e.moves += max(e.pointer-loc, loc-e.pointer)
+	e.pointer = loc
+}
+
+func (e *BFEmitter) add(loc, n int) {
+	e.moveTo(loc)
+	if n > 0 {
+		e.put(e.dialect.Inc, n)
+	} else {
+		e.put(e.dialect.Dec, -n)
+	}
+}
+
+func (e *BFEmitter) Emit(op IR.Op) {
+	d := e.dialect
+	switch op.Kind {
+	case IR.OP_ADD:
+		e.add(op.Loc, op.N)
+	case IR.OP_CLEAR:
+		e.moveTo(op.Loc)
+		e.put(d.Open, 1)
+		e.put(d.Dec, 1)
+		e.put(d.Close, 1)
+	case IR.OP_MOVE:
+		e.moveTo(op.Loc)
+		e.put(d.Open, 1)
+		e.put(d.Dec, 1)
+		for _, t := range op.Targets {
+			e.add(t.Loc, t.Factor)
+		}
+		e.moveTo(op.Loc)
+		e.put(d.Close, 1)
+	case IR.OP_OPEN:
+		e.moveTo(op.Loc)
+		e.put(d.Open, 1)
+		e.depth++
+	case IR.OP_CLOSE:
+		e.depth--
+		e.moveTo(op.Loc)
+		e.put(d.Close, 1)
+	case IR.OP_IN:
+		e.moveTo(op.Loc)
+		e.put(d.Read, 1)
+	case IR.OP_OUT:
+		e.moveTo(op.Loc)
+		e.put(d.Write, 1)
+	case IR.OP_BREAKPOINT:
+		e.put(d.Breakpoint, 1)
+	case IR.OP_MARK:
+		e.pending = append(e.pending, op.N)
+	}
+}
+
+// Pointer returns the cell the pointer is on after the code emitted so far
+func (e *BFEmitter) Pointer() int {
+	return e.pointer
+}
+
+// SetPointer tells the emitter the pointer starts on loc rather than cell 0,
+// for code that continues where earlier code left off
+func (e *BFEmitter) SetPointer(loc int) {
+	e.pointer = loc
+}
+
+// Counts returns the number of tokens and pointer moves written so far
+func (e *BFEmitter) Counts() (instructions, moves int) {
+	return e.instructions, e.moves
+}
+
+// End is where the last of the code ends once the emitter is closed
+func (e *BFEmitter) End() int {
+	return e.end
+}
+
+func (e *BFEmitter) Close() error {
+	e.flushMarks()
+	e.end = e.offset
+	if e.Comment != nil && e.offset > 0 {
+		e.write("\n")
+	}
+	return e.w.Flush()
+}
@@ -0,0 +1,98 @@
+package Backend
+
+import (
+	"braining/IR"
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CEmitter lowers IR to a standalone C program. Each op addresses its cell
+// directly, so there is no pointer; the tape is sized from the highest cell
+// used, which is only known once the whole program has been seen.
+type CEmitter struct {
+	w      *bufio.Writer
+	target *IR.Target
+	body   strings.Builder
+	depth  int
+	maxLoc int
+}
+
+func NewCEmitter(w io.Writer, target *IR.Target) *CEmitter {
+	if target == nil {
+		target = IR.DefaultTarget()
+	}
+	return &CEmitter{w: bufio.NewWriter(w), target: target, depth: 1}
+}
+
+// cellType is the C type that behaves like a cell of the target. Unsigned types
+// wrap the way Brainf*** cells do.
+func cellType(target *IR.Target) string {
+	return fmt.Sprintf("uint%d_t", target.CellBits)
+}
+
+func (e *CEmitter) line(format string, args ...any) {
+	e.body.WriteString(strings.Repeat("\t", e.depth))
+	e.body.WriteString(fmt.Sprintf(format, args...))
+	e.body.WriteString("\n")
+}
+
+func (e *CEmitter) use(loc int) {
+	if loc > e.maxLoc {
+		e.maxLoc = loc
+	}
+}
+
+func (e *CEmitter) Emit(op IR.Op) {
+	e.use(op.Loc)
+	switch op.Kind {
+	case IR.OP_ADD:
+		if op.N >= 0 {
+			e.line("t[%d] += %d;", op.Loc, op.N)
+		} else {
+			e.line("t[%d] -= %d;", op.Loc, -op.N)
+		}
+	case IR.OP_CLEAR:
+		e.line("t[%d] = 0;", op.Loc)
+	case IR.OP_MOVE:
+		e.line("if (t[%d]) {", op.Loc)
+		e.depth++
+		for _, t := range op.Targets {
+			e.use(t.Loc)
+			switch t.Factor {
+			case 1:
+				e.line("t[%d] += t[%d];", t.Loc, op.Loc)
+			case -1:
+				e.line("t[%d] -= t[%d];", t.Loc, op.Loc)
+			default:
+				e.line("t[%d] += (cell)(t[%d] * %d);", t.Loc, op.Loc, t.Factor)
+			}
+		}
+		e.line("t[%d] = 0;", op.Loc)
+		e.depth--
+		e.line("}")
+	case IR.OP_OPEN:
+		e.line("while (t[%d]) {", op.Loc)
+		e.depth++
+	case IR.OP_CLOSE:
+		e.depth--
+		e.line("}")
+	case IR.OP_IN:
+		e.line("if ((c = getchar()) != EOF) t[%d] = (cell)c;", op.Loc)
+	case IR.OP_OUT:
+		e.line("putchar((unsigned char)t[%d]);", op.Loc)
+	case IR.OP_BREAKPOINT:
+		e.line("/* breakpoint */")
+	}
+}
+
+func (e *CEmitter) Close() error {
+	fmt.Fprintf(e.w, "#include <stdint.h>\n#include <stdio.h>\n\n")
+	fmt.Fprintf(e.w, "typedef %s cell;\n\n", cellType(e.target))
+	fmt.Fprintf(e.w, "static cell t[%d];\n\n", e.maxLoc+1)
+	fmt.Fprintf(e.w, "int main(void) {\n\tint c;\n\t(void)c;\n\n")
+	e.w.WriteString(e.body.String())
+	fmt.Fprintf(e.w, "\n\tfflush(stdout);\n\treturn 0;\n}\n")
+	return e.w.Flush()
+}
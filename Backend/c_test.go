@@ -0,0 +1,34 @@
+package Backend_test
+
+import (
+	"braining/Backend"
+	"braining/IR"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestCMatchesBrainfuck builds the C output of every program under testdata
+// with the system compiler and checks it writes what the Brainf*** does
+func TestCMatchesBrainfuck(t *testing.T) {
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("no C compiler")
+	}
+	for _, target := range targets {
+		t.Run(fmt.Sprintf("%d-bit", target.CellBits), func(t *testing.T) {
+			dir := t.TempDir()
+			for _, p := range corpus(t, target) {
+				src := filepath.Join(dir, p.name+".c")
+				build(t, p, target, src, func(w io.Writer) IR.Sink { return Backend.NewCEmitter(w, target) })
+				exe := filepath.Join(dir, p.name)
+				if out, err := exec.Command(cc, "-o", exe, src).CombinedOutput(); err != nil {
+					t.Fatalf("%s: %v\n%s", p.name, err, out)
+				}
+				check(t, p, exe)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package Backend
+
+import (
+	"braining/Logging"
+	"strings"
+)
+
+// Dialect is the alphabet a Brainf*** program is written in. Tokens are
+// separated by Separator; a dialect with no Breakpoint token drops breakpoints.
+type Dialect struct {
+	Name       string
+	Inc        string
+	Dec        string
+	Left       string
+	Right      string
+	Write      string
+	Read       string
+	Open       string
+	Close      string
+	Breakpoint string
+	Separator  string
+}
+
+var BFDialect = &Dialect{
+	Name:       "bf",
+	Inc:        BF_INC,
+	Dec:        BF_DEC,
+	Left:       BF_PTR_L,
+	Right:      BF_PTR_R,
+	Write:      BF_WRITE,
+	Read:       BF_READ,
+	Open:       BF_OPEN,
+	Close:      BF_CLOSE,
+	Breakpoint: BF_BREAKPOINT,
+}
+
+var OokDialect = wordDialect("ook", "Ook")
+
+var BlubDialect = wordDialect("blub", "Blub")
+
+// wordDialect builds one of the Ook!-style dialects, where every instruction
+// is a pair of the same word with different punctuation
+func wordDialect(name, word string) *Dialect {
+	pair := func(a, b string) string {
+		return word + a + " " + word + b
+	}
+	return &Dialect{
+		Name:      name,
+		Inc:       pair(".", "."),
+		Dec:       pair("!", "!"),
+		Left:      pair("?", "."),
+		Right:     pair(".", "?"),
+		Write:     pair("!", "."),
+		Read:      pair(".", "!"),
+		Open:      pair("!", "?"),
+		Close:     pair("?", "!"),
+		Separator: " ",
+	}
+}
+
+var Dialects = map[string]*Dialect{
+	BFDialect.Name:   BFDialect,
+	OokDialect.Name:  OokDialect,
+	BlubDialect.Name: BlubDialect,
+}
+
+// NewDialect builds a custom dialect from tokens in the order
+// inc dec left right write read open close, with an optional ninth token for
+// breakpoints. Tokens longer than one character are separated by spaces. No
+// token may begin with another, so a program reads back the same way whether
+// or not its tokens are separated.
+func NewDialect(tokens []string) (*Dialect, error) {
+	if len(tokens) != 8 && len(tokens) != 9 {
+		return nil, &Logging.InvalidDialectCompilerError{Reason: "expected 8 or 9 tokens"}
+	}
+	separator := ""
+	for i, token := range tokens {
+		if token == "" || strings.ContainsAny(token, " \t\n") {
+			return nil, &Logging.InvalidDialectCompilerError{Reason: "tokens must be non-empty and contain no whitespace"}
+		}
+		for _, other := range tokens[:i] {
+			switch {
+			case other == token:
+				return nil, &Logging.InvalidDialectCompilerError{Reason: "duplicate token " + token}
+			case strings.HasPrefix(token, other):
+				return nil, &Logging.InvalidDialectCompilerError{Reason: "token " + other + " begins " + token}
+			case strings.HasPrefix(other, token):
+				return nil, &Logging.InvalidDialectCompilerError{Reason: "token " + token + " begins " + other}
+			}
+		}
+		if len([]rune(token)) > 1 {
+			separator = " "
+		}
+	}
+
+	d := &Dialect{
+		Name:      "custom",
+		Inc:       tokens[0],
+		Dec:       tokens[1],
+		Left:      tokens[2],
+		Right:     tokens[3],
+		Write:     tokens[4],
+		Read:      tokens[5],
+		Open:      tokens[6],
+		Close:     tokens[7],
+		Separator: separator,
+	}
+	if len(tokens) == 9 {
+		d.Breakpoint = tokens[8]
+	}
+	return d, nil
+}
@@ -0,0 +1,35 @@
+package Backend_test
+
+import (
+	"braining/Backend"
+	"strings"
+	"testing"
+)
+
+func TestNewDialect(t *testing.T) {
+	tests := []struct {
+		tokens string
+		err    string // Part of the error, or empty if the dialect is valid
+	}{
+		{"+ - < > . , [ ]", ""},
+		{"a b c d e f g h i", ""},
+		{"inc dec left right write read open close", ""},
+		{"+ - < > . , [", "expected 8 or 9 tokens"},
+		{"+ - < > . , [ ] # x", "expected 8 or 9 tokens"},
+		{"+ + < > . , [ ]", "duplicate token +"},
+		{"a ab c d e f g h", "token a begins ab"},
+		{"ab b c d e f g a", "token a begins ab"},
+		{"in dec left right out inc open close", "token in begins inc"},
+	}
+	for _, tt := range tests {
+		d, err := Backend.NewDialect(strings.Fields(tt.tokens))
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%q: %v", tt.tokens, err)
+		case tt.err != "" && err == nil:
+			t.Errorf("%q: got %+v, want an error", tt.tokens, *d)
+		case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+			t.Errorf("%q: got %v, want %q", tt.tokens, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package Backend
+
+import (
+	"braining/IR"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	ELF_TEXT_ADDR   = 0x400000   // Where the headers and code are loaded
+	ELF_TAPE_ADDR   = 0x10000000 // Where the zero-filled tape is mapped
+	ELF_HEADER_SIZE = 64
+	ELF_PHDR_SIZE   = 56
+	ELF_CODE_OFFSET = ELF_HEADER_SIZE + 2*ELF_PHDR_SIZE
+)
+
+// Linux x86-64 syscall numbers
+const (
+	SYS_READ  = 0
+	SYS_WRITE = 1
+	SYS_EXIT  = 60
+)
+
+// ELFEmitter assembles IR into a static x86-64 Linux executable with no
+// dependencies. rbx holds the tape address for the whole program and every op
+// addresses its cell as [rbx+disp32]. Input and output go straight through the
+// read and write syscalls; at end of input the cell is left unchanged.
+type ELFEmitter struct {
+	w       *bufio.Writer
+	target  *IR.Target
+	code    bytes.Buffer
+	loops   []int // Offset in code of the rel32 of each open loop's forward jump
+	patches []int // Offsets in code of displacements that address scratch
+	maxLoc  int
+}
+
+func NewELFEmitter(w io.Writer, target *IR.Target) *ELFEmitter {
+	if target == nil {
+		target = IR.DefaultTarget()
+	}
+	e := &ELFEmitter{w: bufio.NewWriter(w), target: target}
+	e.bytes(0x48, 0xBB) // mov rbx, imm64
+	e.imm64(ELF_TAPE_ADDR)
+	return e
+}
+
+func (e *ELFEmitter) bytes(b ...byte) {
+	e.code.Write(b)
+}
+
+func (e *ELFEmitter) imm32(v int) {
+	binary.Write(&e.code, binary.LittleEndian, int32(v))
+}
+
+func (e *ELFEmitter) imm64(v int) {
+	binary.Write(&e.code, binary.LittleEndian, int64(v))
+}
+
+func (e *ELFEmitter) cellSize() int {
+	return e.target.CellBits / 8
+}
+
+// scratch is a byte past the end of the tape that input is read into
+func (e *ELFEmitter) scratch() int {
+	return (e.maxLoc + 1) * e.cellSize()
+}
+
+func (e *ELFEmitter) disp(loc int) int {
+	return loc * e.cellSize()
+}
+
+// prefix emits the operand size override for 16-bit cells
+func (e *ELFEmitter) prefix() {
+	if e.cellSize() == 2 {
+		e.bytes(0x66)
+	}
+}
+
+// addImm adds an immediate to a cell; negative amounts wrap the same way
+func (e *ELFEmitter) addImm(loc, n int) {
+	switch e.cellSize() {
+	case 1:
+		e.bytes(0x80, 0x83) // add byte [rbx+disp32], imm8
+		e.imm32(e.disp(loc))
+		e.bytes(byte(n))
+	case 2:
+		e.bytes(0x66, 0x81, 0x83) // add word [rbx+disp32], imm16
+		e.imm32(e.disp(loc))
+		binary.Write(&e.code, binary.LittleEndian, int16(n))
+	default:
+		e.bytes(0x81, 0x83) // add dword [rbx+disp32], imm32
+		e.imm32(e.disp(loc))
+		e.imm32(n)
+	}
+}
+
+func (e *ELFEmitter) clear(loc int) {
+	switch e.cellSize() {
+	case 1:
+		e.bytes(0xC6, 0x83) // mov byte [rbx+disp32], imm8
+		e.imm32(e.disp(loc))
+		e.bytes(0)
+	case 2:
+		e.bytes(0x66, 0xC7, 0x83) // mov word [rbx+disp32], imm16
+		e.imm32(e.disp(loc))
+		e.bytes(0, 0)
+	default:
+		e.bytes(0xC7, 0x83) // mov dword [rbx+disp32], imm32
+		e.imm32(e.disp(loc))
+		e.imm32(0)
+	}
+}
+
+// load zero-extends a cell into eax
+func (e *ELFEmitter) load(loc int) {
+	switch e.cellSize() {
+	case 1:
+		e.bytes(0x0F, 0xB6, 0x83) // movzx eax, byte [rbx+disp32]
+	case 2:
+		e.bytes(0x0F, 0xB7, 0x83) // movzx eax, word [rbx+disp32]
+	default:
+		e.bytes(0x8B, 0x83) // mov eax, [rbx+disp32]
+	}
+	e.imm32(e.disp(loc))
+}
+
+// addReg adds al/ax/eax (reg 0) or cl/cx/ecx (reg 1) to a cell
+func (e *ELFEmitter) addReg(loc int, reg byte) {
+	e.prefix()
+	if e.cellSize() == 1 {
+		e.bytes(0x00) // add r/m8, r8
+	} else {
+		e.bytes(0x01) // add r/m16/32, r16/32
+	}
+	e.bytes(0x83 | reg<<3)
+	e.imm32(e.disp(loc))
+}
+
+// store writes al/ax/eax into a cell
+func (e *ELFEmitter) store(loc int) {
+	e.prefix()
+	if e.cellSize() == 1 {
+		e.bytes(0x88) // mov r/m8, r8
+	} else {
+		e.bytes(0x89) // mov r/m16/32, r16/32
+	}
+	e.bytes(0x83)
+	e.imm32(e.disp(loc))
+}
+
+// compareZero sets the flags from comparing a cell with zero
+func (e *ELFEmitter) compareZero(loc int) {
+	if e.cellSize() == 1 {
+		e.bytes(0x80, 0xBB) // cmp byte [rbx+disp32], imm8
+	} else {
+		e.prefix()
+		e.bytes(0x83, 0xBB) // cmp word/dword [rbx+disp32], imm8
+	}
+	e.imm32(e.disp(loc))
+	e.bytes(0)
+}
+
+// syscall3 loads rsi with the address of a tape byte and makes a one byte
+// read or write on fd
+func (e *ELFEmitter) syscall3(nr, fd, offset int) {
+	e.bytes(0x48, 0x8D, 0xB3) // lea rsi, [rbx+disp32]
+	e.imm32(offset)
+	e.bytes(0xBF) // mov edi, imm32
+	e.imm32(fd)
+	e.bytes(0xBA) // mov edx, imm32
+	e.imm32(1)
+	e.bytes(0xB8) // mov eax, imm32
+	e.imm32(nr)
+	e.bytes(0x0F, 0x05) // syscall
+}
+
+func (e *ELFEmitter) use(loc int) {
+	if loc > e.maxLoc {
+		e.maxLoc = loc
+	}
+}
+
+func (e *ELFEmitter) Emit(op IR.Op) {
+	e.use(op.Loc)
+	switch op.Kind {
+	case IR.OP_ADD:
+		e.addImm(op.Loc, op.N)
+	case IR.OP_CLEAR:
+		e.clear(op.Loc)
+	case IR.OP_MOVE:
+		e.load(op.Loc)
+		for _, t := range op.Targets {
+			e.use(t.Loc)
+			if t.Factor == 1 {
+				e.addReg(t.Loc, 0)
+				continue
+			}
+			e.bytes(0x69, 0xC8) // imul ecx, eax, imm32
+			e.imm32(t.Factor)
+			e.addReg(t.Loc, 1)
+		}
+		e.clear(op.Loc)
+	case IR.OP_OPEN:
+		e.compareZero(op.Loc)
+		e.bytes(0x0F, 0x84) // je rel32, patched when the loop closes
+		e.loops = append(e.loops, e.code.Len())
+		e.imm32(0)
+	case IR.OP_CLOSE:
+		open := e.loops[len(e.loops)-1]
+		e.loops = e.loops[:len(e.loops)-1]
+		body := open + 4
+
+		e.compareZero(op.Loc)
+		e.bytes(0x0F, 0x85) // jne rel32 back to the start of the body
+		e.imm32(body - (e.code.Len() + 4))
+		binary.LittleEndian.PutUint32(e.code.Bytes()[open:], uint32(e.code.Len()-body))
+	case IR.OP_IN:
+		// Read into scratch so wider cells get a zero-extended byte, and only
+		// store it if a byte actually arrived. Where scratch lives is only known
+		// at Close, so its displacements are patched in there.
+		e.patches = append(e.patches, e.code.Len()+3)
+		e.syscall3(SYS_READ, 0, 0)
+		e.bytes(0x48, 0x83, 0xF8, 0x01) // cmp rax, 1
+		e.bytes(0x75, 0)                // jne past the store
+		skip := e.code.Len()
+		e.bytes(0x0F, 0xB6, 0x83) // movzx eax, byte [rbx+disp32]
+		e.patches = append(e.patches, e.code.Len())
+		e.imm32(0)
+		e.store(op.Loc)
+		e.code.Bytes()[skip-1] = byte(e.code.Len() - skip)
+	case IR.OP_OUT:
+		// Cells are little-endian, so the low byte is at the cell's address
+		e.syscall3(SYS_WRITE, 1, e.disp(op.Loc))
+	}
+}
+
+func (e *ELFEmitter) Close() error {
+	e.bytes(0xB8) // mov eax, SYS_EXIT
+	e.imm32(SYS_EXIT)
+	e.bytes(0x31, 0xFF) // xor edi, edi
+	e.bytes(0x0F, 0x05) // syscall
+
+	for _, at := range e.patches {
+		binary.LittleEndian.PutUint32(e.code.Bytes()[at:], uint32(e.scratch()))
+	}
+
+	fileSize := ELF_CODE_OFFSET + e.code.Len()
+	le := binary.LittleEndian
+
+	header := make([]byte, ELF_HEADER_SIZE)
+	copy(header, []byte{0x7F, 'E', 'L', 'F', 2, 1, 1, 0})
+	le.PutUint16(header[16:], 2)    // ET_EXEC
+	le.PutUint16(header[18:], 0x3E) // EM_X86_64
+	le.PutUint32(header[20:], 1)    // EV_CURRENT
+	le.PutUint64(header[24:], ELF_TEXT_ADDR+ELF_CODE_OFFSET)
+	le.PutUint64(header[32:], ELF_HEADER_SIZE) // Program headers follow the header
+	le.PutUint16(header[52:], ELF_HEADER_SIZE)
+	le.PutUint16(header[54:], ELF_PHDR_SIZE)
+	le.PutUint16(header[56:], 2)
+	e.w.Write(header)
+
+	e.w.Write(programHeader(5, 0, ELF_TEXT_ADDR, fileSize, fileSize))          // R+X: headers and code
+	e.w.Write(programHeader(6, 0, ELF_TAPE_ADDR, 0, e.scratch()+e.cellSize())) // R+W: the tape
+	e.w.Write(e.code.Bytes())
+	return e.w.Flush()
+}
+
+func programHeader(flags uint32, offset, addr, fileSize, memSize int) []byte {
+	le := binary.LittleEndian
+	ph := make([]byte, ELF_PHDR_SIZE)
+	le.PutUint32(ph[0:], 1) // PT_LOAD
+	le.PutUint32(ph[4:], flags)
+	le.PutUint64(ph[8:], uint64(offset))
+	le.PutUint64(ph[16:], uint64(addr))
+	le.PutUint64(ph[24:], uint64(addr))
+	le.PutUint64(ph[32:], uint64(fileSize))
+	le.PutUint64(ph[40:], uint64(memSize))
+	le.PutUint64(ph[48:], 0x1000)
+	return ph
+}
@@ -0,0 +1,33 @@
+package Backend_test
+
+import (
+	"braining/Backend"
+	"braining/IR"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestELFMatchesInterpreter runs the executable of every program under
+// testdata and checks it writes what the Brainf*** does on the interpreter
+func TestELFMatchesInterpreter(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("ELF executables only run on linux/amd64")
+	}
+	for _, target := range targets {
+		t.Run(fmt.Sprintf("%d-bit", target.CellBits), func(t *testing.T) {
+			dir := t.TempDir()
+			for _, p := range corpus(t, target) {
+				exe := filepath.Join(dir, p.name)
+				build(t, p, target, exe, func(w io.Writer) IR.Sink { return Backend.NewELFEmitter(w, target) })
+				if err := os.Chmod(exe, 0o755); err != nil {
+					t.Fatal(err)
+				}
+				check(t, p, exe)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package Backend
+
+import (
+	"braining/IR"
+	"bufio"
+	"fmt"
+	"go/format"
+	"io"
+	"strings"
+)
+
+// GoEmitter lowers IR to Go source. The program becomes a function
+//
+//	func Run(in io.Reader, out io.Writer) error
+//
+// and, when Package is main, a main function that runs it on stdin and stdout,
+// so the output can either be built on its own or dropped into another package.
+type GoEmitter struct {
+	w       *bufio.Writer
+	target  *IR.Target
+	Package string
+	body    strings.Builder
+	depth   int
+	maxLoc  int
+	reads   bool
+}
+
+func NewGoEmitter(w io.Writer, target *IR.Target, pkg string) *GoEmitter {
+	if target == nil {
+		target = IR.DefaultTarget()
+	}
+	if pkg == "" {
+		pkg = "main"
+	}
+	return &GoEmitter{w: bufio.NewWriter(w), target: target, Package: pkg, depth: 1}
+}
+
+func goCellType(target *IR.Target) string {
+	return fmt.Sprintf("uint%d", target.CellBits)
+}
+
+func (e *GoEmitter) line(format string, args ...any) {
+	e.body.WriteString(strings.Repeat("\t", e.depth))
+	e.body.WriteString(fmt.Sprintf(format, args...))
+	e.body.WriteString("\n")
+}
+
+func (e *GoEmitter) use(loc int) {
+	if loc > e.maxLoc {
+		e.maxLoc = loc
+	}
+}
+
+// addTo adds n times expr to a cell; negative constants would not compile
+// against unsigned cells, so they become subtractions
+func (e *GoEmitter) addTo(loc int, n int, expr string) {
+	switch {
+	case n == 1 && expr != "":
+		e.line("t[%d] += %s", loc, expr)
+	case n == -1 && expr != "":
+		e.line("t[%d] -= %s", loc, expr)
+	case expr == "" && n >= 0:
+		e.line("t[%d] += %d", loc, n)
+	case expr == "":
+		e.line("t[%d] -= %d", loc, -n)
+	case n >= 0:
+		e.line("t[%d] += %s * %d", loc, expr, n)
+	default:
+		e.line("t[%d] -= %s * %d", loc, expr, -n)
+	}
+}
+
+func (e *GoEmitter) Emit(op IR.Op) {
+	e.use(op.Loc)
+	switch op.Kind {
+	case IR.OP_ADD:
+		e.addTo(op.Loc, op.N, "")
+	case IR.OP_CLEAR:
+		e.line("t[%d] = 0", op.Loc)
+	case IR.OP_MOVE:
+		e.line("if t[%d] != 0 {", op.Loc)
+		e.depth++
+		for _, t := range op.Targets {
+			e.use(t.Loc)
+			e.addTo(t.Loc, t.Factor, fmt.Sprintf("t[%d]", op.Loc))
+		}
+		e.line("t[%d] = 0", op.Loc)
+		e.depth--
+		e.line("}")
+	case IR.OP_OPEN:
+		e.line("for t[%d] != 0 {", op.Loc)
+		e.depth++
+	case IR.OP_CLOSE:
+		e.depth--
+		e.line("}")
+	case IR.OP_IN:
+		e.reads = true
+		e.line("if b, err := r.ReadByte(); err == nil {")
+		e.line("\tt[%d] = cell(b)", op.Loc)
+		e.line("} else if err != io.EOF {")
+		e.line("\treturn err")
+		e.line("}")
+	case IR.OP_OUT:
+		e.line("if err := w.WriteByte(byte(t[%d])); err != nil {", op.Loc)
+		e.line("\treturn err")
+		e.line("}")
+	case IR.OP_BREAKPOINT:
+		e.line("// breakpoint")
+	}
+}
+
+func (e *GoEmitter) Close() error {
+	var src strings.Builder
+	src.WriteString("// Code generated by braining. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&src, "package %s\n\n", e.Package)
+	src.WriteString("import (\n\t\"bufio\"\n\t\"io\"\n")
+	if e.Package == "main" {
+		src.WriteString("\t\"os\"\n")
+	}
+	src.WriteString(")\n\n")
+	fmt.Fprintf(&src, "type cell = %s\n\n", goCellType(e.target))
+
+	src.WriteString("// Run executes the program, reading input from in and writing output to out\n")
+	src.WriteString("func Run(in io.Reader, out io.Writer) error {\n")
+	fmt.Fprintf(&src, "\tt := make([]cell, %d)\n", e.maxLoc+1)
+	src.WriteString("\tr := bufio.NewReader(in)\n\tw := bufio.NewWriter(out)\n")
+	if !e.reads {
+		src.WriteString("\t_ = r\n")
+	}
+	if e.body.Len() == 0 {
+		src.WriteString("\t_ = t\n")
+	}
+	src.WriteString("\n")
+	src.WriteString(e.body.String())
+	src.WriteString("\n\treturn w.Flush()\n}\n")
+
+	if e.Package == "main" {
+		src.WriteString("\nfunc main() {\n\tif err := Run(os.Stdin, os.Stdout); err != nil {\n\t\tos.Exit(1)\n\t}\n}\n")
+	}
+
+	code, err := format.Source([]byte(src.String()))
+	if err != nil {
+		return err
+	}
+	e.w.Write(code)
+	return e.w.Flush()
+}
@@ -0,0 +1,49 @@
+package Backend_test
+
+import (
+	"braining/Backend"
+	"braining/IR"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestGoMatchesBrainfuck builds the Go output of every program under testdata
+// and checks it writes what the Brainf*** does. All the programs of a target
+// are built with one go build, each as a main package of a scratch module.
+func TestGoMatchesBrainfuck(t *testing.T) {
+	gotool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go command")
+	}
+	for _, target := range targets {
+		t.Run(fmt.Sprintf("%d-bit", target.CellBits), func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module programs\n\ngo 1.23\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			programs := corpus(t, target)
+			for _, p := range programs {
+				if err := os.Mkdir(filepath.Join(dir, p.name), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				src := filepath.Join(dir, p.name, "main.go")
+				build(t, p, target, src, func(w io.Writer) IR.Sink { return Backend.NewGoEmitter(w, target, "main") })
+			}
+
+			bin := filepath.Join(dir, "bin")
+			cmd := exec.Command(gotool, "build", "-o", bin+string(filepath.Separator), "./...")
+			cmd.Dir = dir
+			cmd.Env = append(os.Environ(), "GOFLAGS=", "GOWORK=off")
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("%v\n%s", err, out)
+			}
+			for _, p := range programs {
+				check(t, p, filepath.Join(bin, p.name))
+			}
+		})
+	}
+}
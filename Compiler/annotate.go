@@ -0,0 +1,105 @@
+package Compiler
+
+import (
+	"braining/AST"
+	"fmt"
+	"strings"
+)
+
+type markInfo struct {
+	node  AST.Node
+	end   bool               // Marks the code closing an if or while rather than opening it
+	scope map[string]int     // Variables and their cells as the statement starts
+	call  *AST.MacroCallNode // Outermost macro call the statement was expanded from
+	temps int                // Temps allocated for the code behind the mark
+}
+
+// annotation describes the statement behind a mark: its source text and the
+// cells of the variables it mentions
+func (c *Compiler) annotation(id int) string {
+	m := c.marks[id]
+	text := c.sourceText(m.node.Position())
+	if m.end {
+		text = "end " + text
+	}
+
+	cells := []string{}
+	for _, name := range statementIdents(m.node) {
+		if loc, ok := c.memoryManager.Variables[name]; ok {
+			cells = append(cells, fmt.Sprintf("%s@%d", name, loc))
+		}
+	}
+	if len(cells) > 0 {
+		text += "  (" + strings.Join(cells, " ") + ")"
+	}
+	return text
+}
+
+// sourceText cuts a span out of Source, falling back to its line number
+func (c *Compiler) sourceText(span AST.Span) string {
+	if c.Source == "" {
+		return fmt.Sprintf("line %d", span.Start.Line)
+	}
+	lines := strings.Split(c.Source, "\n")
+	if span.Start.Line < 1 || span.End.Line > len(lines) {
+		return fmt.Sprintf("line %d", span.Start.Line)
+	}
+
+	parts := []string{}
+	for i := span.Start.Line; i <= span.End.Line; i++ {
+		line := []rune(lines[i-1])
+		from, to := 0, len(line)
+		if i == span.Start.Line {
+			from = min(span.Start.Col-1, len(line))
+		}
+		if i == span.End.Line {
+			to = min(span.End.Col-1, len(line))
+		}
+		if from < to {
+			parts = append(parts, strings.TrimSpace(string(line[from:to])))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// statementIdents lists the variables a statement names directly, not those
+// in the body of an if or while
+func statementIdents(node AST.Node) []string {
+	names := []string{}
+	add := func(t AST.Token) {
+		if t != nil && t.Type() == AST.T_IDENT {
+			names = append(names, t.(*AST.IdentToken).Name)
+		}
+	}
+
+	switch n := node.(type) {
+	case *AST.AssignNode:
+		add(&n.Left)
+		add(n.Right)
+	case *AST.AddNode:
+		add(&n.Left)
+		add(n.Right)
+	case *AST.SubNode:
+		add(&n.Left)
+		add(n.Right)
+	case *AST.IfNode:
+		add(&n.Id)
+	case *AST.IfNotNode:
+		add(&n.Id)
+	case *AST.WhileNode:
+		add(&n.Id)
+	case *AST.WhileNotNode:
+		add(&n.Id)
+	case *AST.WriteNode:
+		add(n.Value)
+	case *AST.ReadNode:
+		add(&n.Value)
+	case *AST.FreeNode:
+		add(&n.Value)
+	case *AST.AsmNode:
+		for i := range n.Bindings {
+			add(&n.Bindings[i])
+		}
+	}
+	return names
+}
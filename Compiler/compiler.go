@@ -2,40 +2,131 @@ package Compiler
 
 import (
 	"braining/AST"
+	"braining/Backend"
+	"braining/IR"
+	"braining/Lexer"
 	"braining/Logging"
-	"os"
+	"io"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
 
-// Compiler struct that holds the memory manager, logger, AST, and generated code
+// Compiler struct that holds the memory manager, logger, target, AST, and generated code
 type Compiler struct {
 	memoryManager *MemoryManager
 	logger        *Logging.Logger
+	target        *IR.Target
+	sink          IR.Sink
+	pointer       int // Cell addressed by the last op, which is where the pointer ends up in Brainf***
+	written       *countingWriter
 	Ast           AST.Ast
-	Code          string
+	Code          string // Filled in by Compile, or by CompileTo when CollectCode is set
+	CollectCode   bool
+	OptLevel      int
+	Dialect       *Backend.Dialect // Alphabet of the output; nil means plain Brainf***
+	Annotate      bool             // Lay the output out per statement with the source as comments
+	Source        string           // Program text, quoted by Annotate when set
+
+	SourceMap *SourceMap  // Filled in by CompileTo
+	Costs     *CostReport // Filled in by CompileTo
+
+	liveness *liveness
+	declared map[string]bool    // Variables set and not freed so far in the program text
+	scopes   []map[string]bool  // Variables that existed on entry to each enclosing conditional body
+	marks    []markInfo         // Statement behind each mark, indexed by mark id
+	node     AST.Node           // Statement being compiled, for errors
+	call     *AST.MacroCallNode // Outermost macro call being expanded, for errors
 }
 
-func NewCompiler(ast AST.Ast, logger *Logging.Logger) *Compiler {
+func NewCompiler(ast AST.Ast, target *IR.Target, logger *Logging.Logger) *Compiler {
 	if logger == nil {
 		logger = Logging.NewLoggerWithDefaultColors("braining_compiler", Logging.ERROR) // Crash on error by default
 	}
 
+	if target == nil {
+		target = IR.DefaultTarget()
+	}
+	if !target.Valid() {
+		err := Logging.InvalidTargetCompilerError{Reason: target.Unsupported()}
+		logger.Error(err.Error())
+	}
+
 	return &Compiler{
 		memoryManager: NewMemoryManager(),
 		logger:        logger,
+		target:        target,
 		Ast:           ast,
 		Code:          "",
 	}
 }
 
+// Target returns the target the compiler generates code for
+func (c *Compiler) Target() *IR.Target {
+	return c.target
+}
+
 // ----------------------------------------------------
 // Entry Point
 // ----------------------------------------------------
 
-// Compile starts compiling from the root of the AST
+// Compile compiles the whole AST into Code
 func (c *Compiler) Compile() {
+	c.CollectCode = true
+	c.CompileTo(nil)
+}
+
+// CompileTo streams the compiled program into w as it is generated. Code is
+// only kept as well when CollectCode is set.
+func (c *Compiler) CompileTo(w io.Writer) error {
+	var collect *strings.Builder
+	if c.CollectCode {
+		collect = &strings.Builder{}
+		if w == nil {
+			w = collect
+		} else {
+			w = io.MultiWriter(w, collect)
+		}
+	}
+	c.written = &countingWriter{w: w}
+
+	emitter := Backend.NewBFEmitter(c.written, c.Dialect)
+	if c.Annotate {
+		emitter.Comment = c.annotation
+	}
+	err := c.CompileIR(emitter)
+	c.SourceMap = c.buildSourceMap(emitter.Marks, emitter.End())
+	c.Costs = c.buildCostReport(emitter)
+	if collect != nil {
+		c.Code = collect.String()
+	}
+	return err
+}
+
+// CompileIR lowers the AST into IR ops, optimized for OptLevel, and streams
+// them into sink, which is closed at the end
+func (c *Compiler) CompileIR(sink IR.Sink) error {
+	c.memoryManager = NewMemoryManager()
+	c.liveness = nil
+	c.declared = make(map[string]bool)
+	c.scopes = nil
+	c.marks = nil
+	c.node = nil
+	c.pointer = 0
+	c.sink = IR.NewOptimizer(c.OptLevel, c.target, sink)
+
+	if c.OptLevel >= IR.O_DATAFLOW {
+		c.memoryManager.SetLayout(planLayout(&c.Ast.Root))
+		c.liveness = analyzeLiveness(&c.Ast.Root)
+	}
 	c.compileNode(&c.Ast.Root)
+
+	return c.sink.Close()
+}
+
+// DumpIR writes the IR of the program as text
+func (c *Compiler) DumpIR(w io.Writer) error {
+	return c.CompileIR(IR.NewDumper(w))
 }
 
 // ----------------------------------------------------
@@ -44,53 +135,41 @@ func (c *Compiler) Compile() {
 
 // Increment the value at the given memory location by a specified amount
 func (c *Compiler) inc(loc, val int) {
-	c.inject(c.memoryManager.MovePointer(loc))
-	c.inject(strings.Repeat(BF_INC, val))
+	c.emit(IR.Add(loc, val))
 }
 
 // Decrement the value at the given memory location by a specified amount
 func (c *Compiler) dec(loc, val int) {
-	c.inject(c.memoryManager.MovePointer(loc))
-	c.inject(strings.Repeat(BF_DEC, val))
+	c.emit(IR.Add(loc, -val))
 }
 
 // Clear the value at the given memory location
 func (c *Compiler) clear(loc int) {
-	c.inject(c.memoryManager.MovePointer(loc))
-	c.inject(BF_CLEAR)
-}
-
-// Open and close loops in Brainf***
-func (c *Compiler) open() {
-	c.inject(BF_OPEN)
+	c.emit(IR.Clear(loc))
 }
 
+// Open and close loops on a memory location
 func (c *Compiler) openAt(loc int) {
-	c.inject(c.memoryManager.MovePointer(loc))
-	c.open()
-}
-
-func (c *Compiler) close() {
-	c.inject(BF_CLOSE)
+	c.emit(IR.OpenLoop(loc))
 }
 
 func (c *Compiler) closeAt(loc int) {
-	c.inject(c.memoryManager.MovePointer(loc))
-	c.close()
+	c.emit(IR.CloseLoop(loc))
 }
 
 func (c *Compiler) write(loc int) {
-	c.inject(c.memoryManager.MovePointer(loc))
-	c.inject(BF_WRITE)
+	c.emit(IR.Out(loc))
 }
 
 func (c *Compiler) read(loc int) {
-	c.inject(c.memoryManager.MovePointer(loc))
-	c.inject(BF_READ)
+	c.emit(IR.In(loc))
 }
 
-func (c *Compiler) inject(code string) {
-	c.Code += code
+func (c *Compiler) emit(op IR.Op) {
+	if op.Kind != IR.OP_BREAKPOINT && op.Kind != IR.OP_MARK {
+		c.pointer = op.Loc
+	}
+	c.sink.Emit(op)
 }
 
 // ----------------------------------------------------
@@ -98,29 +177,41 @@ func (c *Compiler) inject(code string) {
 // ----------------------------------------------------
 
 func (c *Compiler) getLoc(name string) int {
-	loc, code := c.memoryManager.GetMemoryLoc(name)
-	c.inject(code)
+	c.declared[name] = true
+	loc, stale := c.memoryManager.GetMemoryLoc(name)
+	if stale {
+		c.clear(loc)
+	}
 	return loc
 }
 
 func (c *Compiler) getClearLoc(name string) int {
-	loc, code := c.memoryManager.GetClearLoc(name)
-	c.inject(code)
+	c.declared[name] = true
+	loc, _ := c.memoryManager.GetMemoryLoc(name)
+	c.clear(loc)
 	return loc
 }
 
-func (c *Compiler) getTemp() int {
-	loc, code := c.memoryManager.GetTempLoc()
-	c.inject(code)
-	return loc
+// getTemp allocates a temp, placed next to near when a layout is in use
+func (c *Compiler) getTemp(near int) int {
+	if len(c.marks) > 0 {
+		c.marks[len(c.marks)-1].temps++
+	}
+	return c.memoryManager.GetTempLocNear(near)
 }
 
 func (c *Compiler) freeTemp(loc int) {
-	c.inject(c.memoryManager.FreeTempLoc(loc))
+	c.memoryManager.FreeTempLoc(loc)
+	c.clear(loc)
+}
+
+// releaseTemp frees a temp that is known to be zero already
+func (c *Compiler) releaseTemp(loc int) {
+	c.memoryManager.FreeTempLoc(loc)
 }
 
 func (c *Compiler) free(name string) {
-	c.inject(c.memoryManager.FreeMemoryLoc(name))
+	c.clear(c.memoryManager.FreeMemoryLoc(name))
 }
 
 // ----------------------------------------------------
@@ -128,159 +219,266 @@ func (c *Compiler) free(name string) {
 // ----------------------------------------------------
 
 func (c *Compiler) copy(from, to int) {
-	tmp := c.getTemp()
+	tmp := c.getTemp(from)
 	c.clear(to)
 
-	c.openAt(from)
-	c.inc(tmp, 1)
-	c.inc(to, 1)
-	c.dec(from, 1)
-	c.closeAt(from)
+	c.emit(IR.Move(from, IR.MoveTarget{Loc: tmp, Factor: 1}, IR.MoveTarget{Loc: to, Factor: 1}))
+	c.emit(IR.Move(tmp, IR.MoveTarget{Loc: from, Factor: 1}))
 
-	c.openAt(tmp)
-	c.inc(from, 1)
-	c.dec(tmp, 1)
-	c.closeAt(tmp)
+	c.releaseTemp(tmp)
+}
 
-	c.freeTemp(tmp)
+// move adds sign times the value of from to to, leaving from at zero
+func (c *Compiler) move(from, to, sign int) {
+	c.emit(IR.Move(from, IR.MoveTarget{Loc: to, Factor: sign}))
 }
 
 func (c *Compiler) add(left, right int) {
-	tmp := c.getTemp()
-
-	c.copy(right, tmp)
+	tmp := c.getTemp(right)
 
-	c.openAt(tmp)
-	c.inc(left, 1)
-	c.dec(tmp, 1)
-	c.closeAt(tmp)
+	c.emit(IR.Move(right, IR.MoveTarget{Loc: tmp, Factor: 1}, IR.MoveTarget{Loc: left, Factor: 1}))
+	c.emit(IR.Move(tmp, IR.MoveTarget{Loc: right, Factor: 1}))
 
-	c.freeTemp(tmp)
+	c.releaseTemp(tmp)
 }
 
 func (c *Compiler) sub(left, right int) {
-	tmp := c.getTemp()
+	tmp := c.getTemp(right)
+
+	if c.target.Saturating() {
+		c.copy(right, tmp)
+		c.subSaturating(left, tmp)
+	} else {
+		c.emit(IR.Move(right, IR.MoveTarget{Loc: tmp, Factor: 1}, IR.MoveTarget{Loc: left, Factor: -1}))
+		c.emit(IR.Move(tmp, IR.MoveTarget{Loc: right, Factor: 1}))
+	}
 
-	c.copy(right, tmp)
+	c.releaseTemp(tmp)
+}
+
+// subSaturating subtracts the value in amount from left, stopping at zero.
+// Amount is consumed (left at zero).
+func (c *Compiler) subSaturating(left, amount int) {
+	flag := c.getTemp(left)
 
-	c.openAt(tmp)
+	c.openAt(amount)
+	c.copy(left, flag)
+	c.openAt(flag)
 	c.dec(left, 1)
-	c.dec(tmp, 1)
-	c.closeAt(tmp)
+	c.clear(flag)
+	c.closeAt(flag)
+	c.dec(amount, 1)
+	c.closeAt(amount)
+
+	c.freeTemp(flag)
+}
 
+// double adds the value of a cell to itself
+func (c *Compiler) double(loc int) {
+	tmp := c.getTemp(loc)
+	c.move(loc, tmp, 1)
+	c.move(tmp, loc, 2)
+	c.releaseTemp(tmp)
+}
+
+// self reports whether a statement's right operand is its left variable. The
+// variable must exist, as for any other right operand.
+func (c *Compiler) self(left AST.IdentToken, right AST.Token) bool {
+	if right.Type() != AST.T_IDENT || right.(*AST.IdentToken).Name != left.Name {
+		return false
+	}
+	if !c.declared[left.Name] {
+		err := Logging.InvalidIdentifierCompilerError{Name: left.Name}
+		c.fail(&err, right)
+	}
+	return true
+}
+
+// checkOperand reports a literal that does not fit in a cell, or a variable
+// that has not been set. Statements check their operands before anything
+// else, so the same programs are rejected whether or not they are optimized
+// away.
+func (c *Compiler) checkOperand(t AST.Token) {
+	if t.Type() == AST.T_LIT {
+		c.literal(t.(*AST.LitToken))
+		return
+	}
+	c.checkDeclared(t.(*AST.IdentToken))
+}
+
+// checkDeclared reports a variable that has not been set, or has been freed.
+// With liveness a variable can be declared but have no cell, after a dead
+// store or once it is freed after its last use.
+func (c *Compiler) checkDeclared(id *AST.IdentToken) {
+	if !c.declared[id.Name] {
+		err := Logging.InvalidIdentifierCompilerError{Name: id.Name}
+		c.fail(&err, id)
+	}
+}
+
+// addConst adds a (possibly negative) constant to a cell, using a multiply loop
+// when that is shorter. On wrapping targets it goes the shorter way around.
+func (c *Compiler) addConst(loc, val int) {
+	val = c.target.Shortest(val)
+	if plan, ok := c.planConstant(loc, val); ok {
+		c.emitConstant(loc, plan)
+		return
+	}
+	if val >= 0 {
+		c.inc(loc, val)
+	} else {
+		c.dec(loc, -val)
+	}
+}
+
+// subConst subtracts a literal, honouring the target's underflow semantics
+func (c *Compiler) subConst(loc, val int) {
+	if !c.target.Saturating() {
+		c.addConst(loc, -val)
+		return
+	}
+
+	tmp := c.getTemp(loc)
+	c.addConst(tmp, val)
+	c.subSaturating(loc, tmp)
 	c.freeTemp(tmp)
 }
 
+// literal parses a literal token and checks that it fits in a cell of the target
+func (c *Compiler) literal(lit *AST.LitToken) int {
+	val, err := strconv.Atoi(lit.Value)
+	if err != nil || val < 0 || val > c.target.MaxLiteral() {
+		err := Logging.InvalidLiteralCompilerError{Value: lit.Value}
+		c.fail(&err, lit)
+	}
+	return val
+}
+
 // ----------------------------------------------------
 // AST Node Compilation
 // ----------------------------------------------------
 
 func (c *Compiler) compileNode(node AST.Node) {
+	if node.Type() != AST.N_BLOCK && node.Type() != AST.N_MACRO {
+		c.mark(node)
+		c.node = node
+	}
+
 	switch node.Type() {
 	case AST.N_BLOCK:
 		n := node.(*AST.BlockNode)
+		if n.Call != nil && c.call == nil {
+			c.call = n.Call
+			defer func() { c.call = nil }()
+		}
 		for _, child := range n.Nodes {
 			c.compileNode(child)
+			c.freeDead(child)
 		}
 
 	case AST.N_ASSIGN:
 		n := node.(*AST.AssignNode)
+		c.checkOperand(n.Right)
+		if c.liveness.dead(n, n.Left.Name) {
+			c.declared[n.Left.Name] = true
+			break
+		}
+		if c.self(n.Left, n.Right) {
+			break // Assigning a variable to itself changes nothing
+		}
 		left := c.getClearLoc(n.Left.Name)
 		if n.Right.Type() == AST.T_LIT {
-			val, err := strconv.Atoi(n.Right.(*AST.LitToken).Value)
-			if err != nil {
-				err := Logging.InvalidLiteralCompilerError{Value: n.Right.(*AST.LitToken).Value}
-				c.logger.Error(err.Error())
-			}
-			c.inc(left, val)
+			c.addConst(left, c.literal(n.Right.(*AST.LitToken)))
 		} else {
-			if !c.memoryManager.IdentifierExists(n.Right.(*AST.IdentToken).Name) {
-				err := Logging.InvalidIdentifierCompilerError{Name: n.Right.(*AST.IdentToken).Name}
-				c.logger.Error(err.Error())
+			right := c.getLoc(n.Right.(*AST.IdentToken).Name)
+			if c.consumable(n, n.Right.(*AST.IdentToken).Name, left, right) {
+				c.move(right, left, 1)
+			} else {
+				c.copy(right, left)
 			}
-			c.copy(c.getLoc(n.Right.(*AST.IdentToken).Name), left)
 		}
 
 	case AST.N_ADD:
 		n := node.(*AST.AddNode)
+		c.checkOperand(n.Right)
+		if c.liveness.dead(n, n.Left.Name) {
+			c.declared[n.Left.Name] = true
+			break
+		}
+		self := c.self(n.Left, n.Right)
 		left := c.getLoc(n.Left.Name)
-		if n.Right.Type() == AST.T_LIT {
-			val, err := strconv.Atoi(n.Right.(*AST.LitToken).Value)
-			if err != nil {
-				err := Logging.InvalidLiteralCompilerError{Value: n.Right.(*AST.LitToken).Value}
-				c.logger.Error(err.Error())
-			}
-			c.inc(left, val)
+		if self {
+			c.double(left)
+		} else if n.Right.Type() == AST.T_LIT {
+			c.addConst(left, c.literal(n.Right.(*AST.LitToken)))
 		} else {
-			if !c.memoryManager.IdentifierExists(n.Right.(*AST.IdentToken).Name) {
-				err := Logging.InvalidIdentifierCompilerError{Name: n.Right.(*AST.IdentToken).Name}
-				c.logger.Error(err.Error())
+			right := c.getLoc(n.Right.(*AST.IdentToken).Name)
+			if c.consumable(n, n.Right.(*AST.IdentToken).Name, left, right) {
+				c.move(right, left, 1)
+			} else {
+				c.add(left, right)
 			}
-			c.add(left, c.getLoc(n.Right.(*AST.IdentToken).Name))
 		}
 
 	case AST.N_SUB:
 		n := node.(*AST.SubNode)
+		c.checkOperand(n.Right)
+		if c.liveness.dead(n, n.Left.Name) {
+			c.declared[n.Left.Name] = true
+			break
+		}
+		self := c.self(n.Left, n.Right)
 		left := c.getLoc(n.Left.Name)
-		if n.Right.Type() == AST.T_LIT {
-			val, err := strconv.Atoi(n.Right.(*AST.LitToken).Value)
-			if err != nil {
-				err := Logging.InvalidLiteralCompilerError{Value: n.Right.(*AST.LitToken).Value}
-				c.logger.Error(err.Error())
-			}
-			c.dec(left, val)
+		if self {
+			c.clear(left)
+		} else if n.Right.Type() == AST.T_LIT {
+			c.subConst(left, c.literal(n.Right.(*AST.LitToken)))
 		} else {
-			if !c.memoryManager.IdentifierExists(n.Right.(*AST.IdentToken).Name) {
-				err := Logging.InvalidIdentifierCompilerError{Name: n.Right.(*AST.IdentToken).Name}
-				c.logger.Error(err.Error())
+			right := c.getLoc(n.Right.(*AST.IdentToken).Name)
+			if !c.consumable(n, n.Right.(*AST.IdentToken).Name, left, right) {
+				c.sub(left, right)
+			} else if c.target.Saturating() {
+				c.subSaturating(left, right)
+			} else {
+				c.move(right, left, -1)
 			}
-			c.sub(left, c.getLoc(n.Right.(*AST.IdentToken).Name))
 		}
 
 	case AST.N_WRITE:
 		n := node.(*AST.WriteNode)
+		c.checkOperand(n.Value)
 		if n.Value.Type() == AST.T_LIT {
-			val, err := strconv.Atoi(n.Value.(*AST.LitToken).Value)
-			if err != nil {
-				err := Logging.InvalidLiteralCompilerError{Value: n.Value.(*AST.LitToken).Value}
-				c.logger.Error(err.Error())
-			}
-			tmp := c.getTemp()
-			c.inc(tmp, val)
+			tmp := c.getTemp(c.pointer)
+			c.addConst(tmp, c.literal(n.Value.(*AST.LitToken)))
 			c.write(tmp)
 			c.freeTemp(tmp)
 		} else {
-			if !c.memoryManager.IdentifierExists(n.Value.(*AST.IdentToken).Name) {
-				err := Logging.InvalidIdentifierCompilerError{Name: n.Value.(*AST.IdentToken).Name}
-				c.logger.Error(err.Error())
-			}
 			c.write(c.getLoc(n.Value.(*AST.IdentToken).Name))
 		}
 
 	case AST.N_READ:
 		n := node.(*AST.ReadNode)
-		if !c.memoryManager.IdentifierExists(n.Value.Name) {
-			err := Logging.InvalidIdentifierCompilerError{Name: n.Value.Name}
-			c.logger.Error(err.Error())
-		}
+		c.checkDeclared(&n.Value)
 		c.read(c.getLoc(n.Value.Name))
 
 	case AST.N_FREE:
 		n := node.(*AST.FreeNode)
-		if !c.memoryManager.IdentifierExists(n.Value.Name) {
-			err := Logging.InvalidIdentifierCompilerError{Name: n.Value.Name}
-			c.logger.Error(err.Error())
+		c.checkDeclared(&n.Value)
+		delete(c.declared, n.Value.Name)
+		// With liveness the variable may already be gone after its last use
+		if c.memoryManager.IdentifierExists(n.Value.Name) {
+			c.free(n.Value.Name)
 		}
-		c.free(n.Value.Name)
 
 	case AST.N_IF:
 		n := node.(*AST.IfNode)
 		id := c.getLoc(n.Id.Name)
-		tmp := c.getTemp()
+		tmp := c.getTemp(id)
 		c.copy(id, tmp)
 
 		c.openAt(tmp)
-		c.compileNode(&n.Block)
+		c.compileBody(&n.Block)
+		c.markEnd(n)
 		c.clear(tmp)
 		c.closeAt(tmp)
 
@@ -290,8 +488,8 @@ func (c *Compiler) compileNode(node AST.Node) {
 		n := node.(*AST.IfNotNode)
 		id := c.getLoc(n.Id.Name)
 
-		tmp := c.getTemp()
-		tmp2 := c.getTemp()
+		tmp := c.getTemp(id)
+		tmp2 := c.getTemp(id)
 
 		c.copy(id, tmp)
 		c.inc(tmp2, 1)
@@ -303,7 +501,8 @@ func (c *Compiler) compileNode(node AST.Node) {
 
 		c.openAt(tmp2)
 		c.dec(tmp2, 1)
-		c.compileNode(&n.Block)
+		c.compileBody(&n.Block)
+		c.markEnd(n)
 		c.closeAt(tmp2)
 
 		c.freeTemp(tmp)
@@ -314,15 +513,16 @@ func (c *Compiler) compileNode(node AST.Node) {
 		id := c.getLoc(n.Id.Name)
 
 		c.openAt(id)
-		c.compileNode(&n.Block)
+		c.compileLoopBody(&n.Block)
+		c.markEnd(n)
 		c.closeAt(id)
 
 	case AST.N_WHILENOT:
 		n := node.(*AST.WhileNotNode)
 		id := c.getLoc(n.Id.Name)
 
-		tmp := c.getTemp()
-		tmp2 := c.getTemp()
+		tmp := c.getTemp(id)
+		tmp2 := c.getTemp(id)
 
 		c.copy(id, tmp)
 		c.inc(tmp2, 1)
@@ -335,13 +535,14 @@ func (c *Compiler) compileNode(node AST.Node) {
 		c.openAt(tmp2)
 		c.dec(tmp2, 1)
 
-		tmp3 := c.getTemp()
+		tmp3 := c.getTemp(id)
 		c.inc(tmp3, 1)
 		c.openAt(tmp3)
 
-		c.compileNode(&n.Block)
+		c.compileLoopBody(&n.Block)
+		c.markEnd(n)
 
-		tmp4 := c.getTemp()
+		tmp4 := c.getTemp(id)
 		c.copy(id, tmp4)
 
 		c.openAt(tmp4)
@@ -360,25 +561,203 @@ func (c *Compiler) compileNode(node AST.Node) {
 		c.freeTemp(tmp4)
 
 	case AST.N_BREAKPOINT:
-		c.inject(BF_BREAKPOINT)
+		c.emit(IR.Breakpoint())
+
+	case AST.N_ASM:
+		c.compileAsm(node.(*AST.AsmNode))
 	}
 }
 
-func (c *Compiler) WriteToFile(path string) {
-	f, err := os.Create(path)
-	if err != nil {
-		c.logger.Error(err.Error())
+// fail reports err at token, or at the statement being compiled when token is
+// nil. Errors inside a macro expansion are reported at the call.
+func (c *Compiler) fail(err error, token AST.Token) {
+	var span AST.Span
+	switch {
+	case c.call != nil:
+		span = c.call.Span
+	case token != nil:
+		span = tokenSpan(token)
+	case c.node != nil:
+		span = c.node.Position()
+	default:
+		c.logger.Raise(err)
+	}
+	c.logger.Raise(&Logging.SourceError{
+		Err:     err,
+		Line:    span.Start.Line,
+		Col:     span.Start.Col,
+		EndLine: span.End.Line,
+		EndCol:  span.End.Col,
+	})
+}
+
+func tokenSpan(t AST.Token) AST.Span {
+	var pos AST.Pos
+	var text string
+	switch t.Type() {
+	case AST.T_IDENT:
+		pos, text = t.(*AST.IdentToken).Pos, t.(*AST.IdentToken).Name
+	case AST.T_LIT:
+		lit := t.(*AST.LitToken)
+		pos, text = lit.Pos, lit.Text
+		if text == "" {
+			text = lit.Value
+		}
+	}
+	return AST.Span{Start: pos, End: AST.Pos{Line: pos.Line, Col: pos.Col + len([]rune(text))}}
+}
+
+// mark attributes the code that follows to a statement, until the next mark
+func (c *Compiler) mark(node AST.Node) {
+	c.marks = append(c.marks, markInfo{node: node, scope: c.memoryManager.Snapshot(), call: c.call})
+	c.emit(IR.Mark(len(c.marks) - 1))
+}
+
+// markEnd attributes the code that closes an if or while to it
+func (c *Compiler) markEnd(node AST.Node) {
+	c.marks = append(c.marks, markInfo{node: node, end: true, scope: c.memoryManager.Snapshot(), call: c.call})
+	c.emit(IR.Mark(len(c.marks) - 1))
+}
+
+// compileBody compiles the body of a conditional or loop. Variables that
+// existed before the body are only freed once the whole statement is done,
+// since the body might not run.
+func (c *Compiler) compileBody(block *AST.BlockNode) {
+	existing := make(map[string]bool, len(c.memoryManager.Variables))
+	for name := range c.memoryManager.Variables {
+		existing[name] = true
 	}
-	defer f.Close()
+	c.scopes = append(c.scopes, existing)
+	c.compileNode(block)
+	c.scopes = c.scopes[:len(c.scopes)-1]
+}
+
+func (c *Compiler) compileLoopBody(block *AST.BlockNode) {
+	c.memoryManager.EnterLoop()
+	c.compileBody(block)
+	c.memoryManager.ExitLoop()
+}
 
-	n, err := f.WriteString(c.Code)
+// freeDead frees the variables mentioned by a statement that are never read again
+func (c *Compiler) freeDead(node AST.Node) {
+	if c.liveness == nil {
+		return
+	}
+	for name := range c.liveness.refs[node] {
+		if !c.liveness.dead(node, name) || !c.memoryManager.IdentifierExists(name) {
+			continue
+		}
+		if len(c.scopes) > 0 && c.scopes[len(c.scopes)-1][name] {
+			continue
+		}
+		if loc, clear := c.memoryManager.ReleaseMemoryLoc(name); clear {
+			c.clear(loc)
+		}
+	}
+}
+
+// consumable reports whether the right operand of a statement can be used up
+// instead of copied, because its value is not needed afterwards
+func (c *Compiler) consumable(node AST.Node, name string, left, right int) bool {
+	return left != right && c.liveness.dead(node, name)
+}
+
+// ASM_CELL_OPS are the asm instructions that act on the cell under the pointer
+const ASM_CELL_OPS = Backend.BF_INC + Backend.BF_DEC + Backend.BF_WRITE + Backend.BF_READ + Backend.BF_OPEN + Backend.BF_CLOSE
+
+// compileAsm lowers a raw snippet starting from the cell of its first binding.
+// The snippet sees its bindings on consecutive cells in the order they are
+// declared, wherever the variables really are, so > and < step between
+// bindings and each op is lowered onto the variable's own cell. The bindings
+// are all the cells a snippet may touch: it can move past them, but not
+// change, test or do I/O there. Every loop and the snippet itself must end on
+// the binding they started on. |Comments| in the snippet are skipped.
+func (c *Compiler) compileAsm(n *AST.AsmNode) {
+	cells := make([]int, len(n.Bindings))
+	index := make(map[string]int)
+	for i, binding := range n.Bindings {
+		cells[i] = c.getLoc(binding.Name)
+		if _, ok := index[binding.Name]; !ok {
+			index[binding.Name] = i
+		}
+	}
+
+	at := 0 // Binding under the pointer, which may be past either end
+	loops := []int{}
+	for _, part := range n.Parts {
+		if part.Ref.Name != "" {
+			at = index[part.Ref.Name]
+		}
+		comment := false
+		for _, r := range part.Code {
+			if r == Lexer.COMMENT_SYMBOL {
+				comment = !comment
+			}
+			if comment {
+				continue
+			}
+			op := string(r)
+			if strings.ContainsRune(ASM_CELL_OPS, r) && (at < 0 || at >= len(cells)) {
+				err := Logging.UnboundCellAsmCompilerError{Op: op, Offset: at, Origin: n.Bindings[0].Name}
+				c.fail(&err, nil)
+			}
+			switch op {
+			case Backend.BF_PTR_R:
+				at++
+			case Backend.BF_PTR_L:
+				at--
+			case Backend.BF_INC:
+				c.inc(cells[at], 1)
+			case Backend.BF_DEC:
+				c.dec(cells[at], 1)
+			case Backend.BF_WRITE:
+				c.write(cells[at])
+			case Backend.BF_READ:
+				c.read(cells[at])
+			case Backend.BF_BREAKPOINT:
+				c.emit(IR.Breakpoint())
+			case Backend.BF_OPEN:
+				loops = append(loops, at)
+				c.openAt(cells[at])
+			case Backend.BF_CLOSE:
+				if len(loops) == 0 {
+					err := Logging.UnbalancedAsmCompilerError{Reason: "unmatched " + Backend.BF_CLOSE}
+					c.fail(&err, nil)
+				}
+				if loops[len(loops)-1] != at {
+					err := Logging.UnbalancedAsmCompilerError{Reason: "loop does not end on the cell it started on"}
+					c.fail(&err, nil)
+				}
+				loops = loops[:len(loops)-1]
+				c.closeAt(cells[at])
+			}
+		}
+	}
+
+	if len(loops) != 0 {
+		err := Logging.UnbalancedAsmCompilerError{Reason: "unmatched " + Backend.BF_OPEN}
+		c.fail(&err, nil)
+	}
+	if at != 0 {
+		err := Logging.UnbalancedAsmCompilerError{Reason: "pointer does not return to " + n.Bindings[0].Name}
+		c.fail(&err, nil)
+	}
+}
+
+// WriteToFile compiles the program into the file at path, with its source map
+// next to it. Neither file is touched when compiling fails.
+func (c *Compiler) WriteToFile(path string) {
+	err := WriteFile(path, 0644, func(w io.Writer) error {
+		return c.CompileTo(w)
+	})
 	if err != nil {
 		c.logger.Error(err.Error())
 	}
 
-	c.logger.Info("Wrote " + strconv.Itoa(n) + " bytes to " + path)
+	c.logger.Info("Wrote " + strconv.Itoa(c.written.n) + " bytes to " + path)
 
-	err = f.Sync()
+	c.SourceMap.File = filepath.Base(path)
+	err = WriteFile(path+SOURCE_MAP_EXT, 0644, c.SourceMap.Write)
 	if err != nil {
 		c.logger.Error(err.Error())
 	}
@@ -0,0 +1,80 @@
+package Compiler
+
+import (
+	"braining/IR"
+)
+
+// constPlan materialises a constant as factor * step + rest: a scratch cell is
+// set to factor, then a loop adds step to the target once per unit of factor
+type constPlan struct {
+	factor int
+	step   int // Negative steps decrement the target
+	rest   int
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// constCost is the exact number of instructions emitted for a plan, starting
+// with the pointer at ptr
+func constCost(plan constPlan, ptr, loc, scratch int) int {
+	d := abs(scratch - loc)
+	return abs(ptr-scratch) + plan.factor + 1 + d + abs(plan.step) + d + 1 + 1 + d + abs(plan.rest)
+}
+
+// planConstant picks the cheapest multiply-loop form of adding val to loc.
+// It reports false when a plain run of +/- is at least as short.
+func (c *Compiler) planConstant(loc, val int) (constPlan, bool) {
+	ptr := c.pointer
+	scratch := c.memoryManager.PeekTempLocNear(loc)
+	bestCost := abs(ptr-loc) + abs(val)
+
+	candidates := []int{val}
+	if c.target.Wrapping && val != 0 {
+		if val > 0 {
+			candidates = append(candidates, val-c.target.Modulus())
+		} else {
+			candidates = append(candidates, val+c.target.Modulus())
+		}
+	}
+
+	var best constPlan
+	found := false
+	for _, v := range candidates {
+		sign := 1
+		if v < 0 {
+			sign = -1
+		}
+		mag := abs(v)
+		for factor := 2; factor*factor <= mag; factor++ {
+			for _, step := range []int{mag / factor, mag/factor + 1} {
+				rest := mag - factor*step
+				// Overshooting and coming back down is only safe if the cell wraps
+				if rest < 0 && !c.target.Wrapping {
+					continue
+				}
+				plan := constPlan{factor: factor, step: sign * step, rest: sign * rest}
+				if cost := constCost(plan, ptr, loc, scratch); cost < bestCost {
+					best, bestCost, found = plan, cost, true
+				}
+			}
+		}
+	}
+	return best, found
+}
+
+func (c *Compiler) emitConstant(loc int, plan constPlan) {
+	scratch := c.getTemp(loc)
+
+	c.inc(scratch, plan.factor)
+	c.emit(IR.Move(scratch, IR.MoveTarget{Loc: loc, Factor: plan.step}))
+	if plan.rest != 0 {
+		c.inc(loc, plan.rest)
+	}
+
+	c.releaseTemp(scratch)
+}
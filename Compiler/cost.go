@@ -0,0 +1,116 @@
+package Compiler
+
+import (
+	"braining/AST"
+	"braining/Backend"
+	"encoding/json"
+	"io"
+)
+
+// Cost is what a piece of the program adds to the generated Brainf***
+type Cost struct {
+	Instructions int `json:"instructions"`
+	Moves        int `json:"moves"` // Pointer moves, counted once per < or >
+	Temps        int `json:"temps"` // Temp cells allocated
+}
+
+func (c *Cost) add(o Cost) {
+	c.Instructions += o.Instructions
+	c.Moves += o.Moves
+	c.Temps += o.Temps
+}
+
+// CostReport lists the cost of every statement outside of macro expansions,
+// and the total of every expansion at its call. The cost of an if or while
+// covers its test and loop only; its body is listed statement by statement.
+// Lines and columns are 1-based.
+type CostReport struct {
+	Statements []CostEntry `json:"statements"`
+	Expansions []CostEntry `json:"expansions"`
+	Total      Cost        `json:"total"`
+}
+
+type CostEntry struct {
+	Cost
+	Macro   string `json:"macro,omitempty"` // Macro expanded, for expansions
+	Text    string `json:"text"`
+	Line    int    `json:"line"`
+	Col     int    `json:"col"`
+	EndLine int    `json:"endLine"`
+	EndCol  int    `json:"endCol"`
+}
+
+// buildCostReport splits the counts of the emitter between the marks, each of
+// which lasts until the next one
+func (c *Compiler) buildCostReport(e *Backend.BFEmitter) *CostReport {
+	r := &CostReport{Statements: []CostEntry{}, Expansions: []CostEntry{}}
+	instructions, moves := e.Counts()
+	r.Total = Cost{Instructions: instructions, Moves: moves}
+
+	statements := make(map[AST.Node]int)
+	expansions := make(map[*AST.MacroCallNode]int)
+	for i, mark := range e.Marks {
+		info := c.marks[mark.Id]
+		cost := Cost{Instructions: instructions - mark.Instructions, Moves: moves - mark.Moves, Temps: info.temps}
+		if i+1 < len(e.Marks) {
+			next := e.Marks[i+1]
+			cost.Instructions = next.Instructions - mark.Instructions
+			cost.Moves = next.Moves - mark.Moves
+		}
+		r.Total.Temps += info.temps
+
+		if info.call != nil {
+			j, ok := expansions[info.call]
+			if !ok {
+				j = len(r.Expansions)
+				expansions[info.call] = j
+				r.Expansions = append(r.Expansions, c.costEntry(info.call.Span))
+				r.Expansions[j].Macro = info.call.Name.Name
+			}
+			r.Expansions[j].add(cost)
+			continue
+		}
+		j, ok := statements[info.node]
+		if !ok {
+			j = len(r.Statements)
+			statements[info.node] = j
+			r.Statements = append(r.Statements, c.costEntry(info.node.Position()))
+		}
+		r.Statements[j].add(cost)
+	}
+	return r
+}
+
+func (c *Compiler) costEntry(span AST.Span) CostEntry {
+	return CostEntry{
+		Text:    c.sourceText(span),
+		Line:    span.Start.Line,
+		Col:     span.Start.Col,
+		EndLine: span.End.Line,
+		EndCol:  span.End.Col,
+	}
+}
+
+// Lookup returns the statement or expansion whose span holds a position
+func (r *CostReport) Lookup(line, col int) (CostEntry, bool) {
+	for _, entries := range [][]CostEntry{r.Expansions, r.Statements} {
+		for _, e := range entries {
+			if e.contains(line, col) {
+				return e, true
+			}
+		}
+	}
+	return CostEntry{}, false
+}
+
+func (e CostEntry) contains(line, col int) bool {
+	after := line > e.Line || line == e.Line && col >= e.Col
+	before := line < e.EndLine || line == e.EndLine && col <= e.EndCol
+	return after && before
+}
+
+func (r *CostReport) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
@@ -0,0 +1,224 @@
+package Compiler
+
+import (
+	"braining/AST"
+	"maps"
+	"sort"
+)
+
+const (
+	LAYOUT_LOOP_WEIGHT = 8 // Accesses inside a loop body count this many times more
+	LAYOUT_GAP         = 1 // Free cells left after each variable for its temps
+)
+
+// accessGraph counts how often the pointer travels between each pair of variables
+type accessGraph struct {
+	order  []string // Variables in order of first access
+	total  map[string]int
+	edges  map[string]map[string]int
+	prev   string
+	weight int
+
+	// Asm bindings that can sit on consecutive cells in declared order: next
+	// and back link each binding to its neighbours
+	next map[string]string
+	back map[string]string
+}
+
+func newAccessGraph() *accessGraph {
+	return &accessGraph{
+		total:  make(map[string]int),
+		edges:  make(map[string]map[string]int),
+		weight: 1,
+		next:   make(map[string]string),
+		back:   make(map[string]string),
+	}
+}
+
+func (g *accessGraph) access(name string) {
+	if _, ok := g.total[name]; !ok {
+		g.order = append(g.order, name)
+		g.edges[name] = make(map[string]int)
+	}
+	g.total[name] += g.weight
+	if g.prev != "" && g.prev != name {
+		g.edges[g.prev][name] += g.weight
+		g.edges[name][g.prev] += g.weight
+	}
+	g.prev = name
+}
+
+func (g *accessGraph) accessToken(t AST.Token) {
+	if t.Type() == AST.T_IDENT {
+		g.access(t.(*AST.IdentToken).Name)
+	}
+}
+
+func (g *accessGraph) loop(id string, block *AST.BlockNode) {
+	g.access(id)
+	g.weight *= LAYOUT_LOOP_WEIGHT
+	g.walk(block)
+	g.access(id)
+	g.weight /= LAYOUT_LOOP_WEIGHT
+}
+
+// walk records accesses in the order the compiler visits the variables
+func (g *accessGraph) walk(node AST.Node) {
+	switch node.Type() {
+	case AST.N_BLOCK:
+		for _, child := range node.(*AST.BlockNode).Nodes {
+			g.walk(child)
+		}
+	case AST.N_ASSIGN:
+		n := node.(*AST.AssignNode)
+		g.access(n.Left.Name)
+		g.accessToken(n.Right)
+	case AST.N_ADD:
+		n := node.(*AST.AddNode)
+		g.access(n.Left.Name)
+		g.accessToken(n.Right)
+	case AST.N_SUB:
+		n := node.(*AST.SubNode)
+		g.access(n.Left.Name)
+		g.accessToken(n.Right)
+	case AST.N_IF:
+		n := node.(*AST.IfNode)
+		g.access(n.Id.Name)
+		g.walk(&n.Block)
+	case AST.N_IFNOT:
+		n := node.(*AST.IfNotNode)
+		g.access(n.Id.Name)
+		g.walk(&n.Block)
+	case AST.N_WHILE:
+		n := node.(*AST.WhileNode)
+		g.loop(n.Id.Name, &n.Block)
+	case AST.N_WHILENOT:
+		n := node.(*AST.WhileNotNode)
+		g.loop(n.Id.Name, &n.Block)
+	case AST.N_WRITE:
+		g.accessToken(node.(*AST.WriteNode).Value)
+	case AST.N_READ:
+		g.access(node.(*AST.ReadNode).Value.Name)
+	case AST.N_FREE:
+		g.access(node.(*AST.FreeNode).Value.Name)
+	case AST.N_ASM:
+		n := node.(*AST.AsmNode)
+		for _, binding := range n.Bindings {
+			g.access(binding.Name)
+		}
+		g.chain(n.Bindings)
+		for _, part := range n.Parts {
+			if part.Ref.Name != "" {
+				g.access(part.Ref.Name)
+			}
+		}
+	}
+}
+
+// chain asks for bindings to be placed side by side in the order given. Blocks
+// that disagree with an earlier one about a neighbour, or would close a loop,
+// are left out; their bindings then go wherever the arrangement puts them.
+func (g *accessGraph) chain(bindings []AST.IdentToken) {
+	next, back := maps.Clone(g.next), maps.Clone(g.back)
+	for i := 1; i < len(bindings); i++ {
+		a, b := bindings[i-1].Name, bindings[i].Name
+		if next[a] == b {
+			continue
+		}
+		if next[a] != "" || back[b] != "" || head(back, a) == b {
+			return
+		}
+		next[a], back[b] = b, a
+	}
+	g.next, g.back = next, back
+}
+
+// head returns the first binding of the chain name is in
+func head(back map[string]string, name string) string {
+	for back[name] != "" {
+		name = back[name]
+	}
+	return name
+}
+
+// cost is the total weighted pointer travel for an arrangement
+func (g *accessGraph) cost(layout map[string]int) int {
+	total := 0
+	for a, neighbours := range g.edges {
+		for b, w := range neighbours {
+			d := layout[a] - layout[b]
+			if d < 0 {
+				d = -d
+			}
+			total += w * d
+		}
+	}
+	return total / 2
+}
+
+// place lays variables out in order, each followed by room for its temps.
+// Chained asm bindings are laid out together where the first of them comes.
+func (g *accessGraph) place(order []string) map[string]int {
+	layout := make(map[string]int, len(order))
+	loc := 0
+	for _, name := range order {
+		if _, ok := layout[name]; ok {
+			continue
+		}
+		for name = head(g.back, name); name != ""; name = g.next[name] {
+			layout[name] = loc
+			loc++
+		}
+		loc += LAYOUT_GAP
+	}
+	return layout
+}
+
+// arrange orders the variables in a line, greedily growing it from the most used
+// variable by attaching whichever remaining variable is most strongly connected
+// to one of its ends
+func (g *accessGraph) arrange() []string {
+	if len(g.order) == 0 {
+		return nil
+	}
+
+	remaining := append([]string(nil), g.order...)
+	sort.SliceStable(remaining, func(i, j int) bool {
+		return g.total[remaining[i]] > g.total[remaining[j]]
+	})
+
+	line := []string{remaining[0]}
+	remaining = remaining[1:]
+	for len(remaining) > 0 {
+		best, bestW, atFront := 0, -1, false
+		for i, name := range remaining {
+			if w := g.edges[line[0]][name]; w > bestW {
+				best, bestW, atFront = i, w, true
+			}
+			if w := g.edges[line[len(line)-1]][name]; w > bestW {
+				best, bestW, atFront = i, w, false
+			}
+		}
+		if atFront {
+			line = append([]string{remaining[best]}, line...)
+		} else {
+			line = append(line, remaining[best])
+		}
+		remaining = append(remaining[:best], remaining[best+1:]...)
+	}
+	return line
+}
+
+// planLayout assigns every variable in the program a fixed cell so that
+// variables used together sit close together. The first-come order is kept
+// when the greedy arrangement does not beat it.
+func planLayout(root *AST.BlockNode) map[string]int {
+	g := newAccessGraph()
+	g.walk(root)
+
+	layout := g.place(g.order)
+	if arranged := g.place(g.arrange()); g.cost(arranged) < g.cost(layout) {
+		layout = arranged
+	}
+	return layout
+}
@@ -0,0 +1,180 @@
+package Compiler
+
+import (
+	"braining/AST"
+)
+
+type liveSet map[string]bool
+
+func (s liveSet) with(names ...string) liveSet {
+	res := make(liveSet, len(s)+len(names))
+	for name := range s {
+		res[name] = true
+	}
+	for _, name := range names {
+		res[name] = true
+	}
+	return res
+}
+
+func (s liveSet) without(name string) liveSet {
+	res := s.with()
+	delete(res, name)
+	return res
+}
+
+func (s liveSet) union(other liveSet) liveSet {
+	res := s.with()
+	for name := range other {
+		res[name] = true
+	}
+	return res
+}
+
+func (s liveSet) equals(other liveSet) bool {
+	if len(s) != len(other) {
+		return false
+	}
+	for name := range s {
+		if !other[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// liveness records, for every statement, the variables whose current value may
+// still be read after it runs, and every variable the statement mentions.
+// Stores to variables that are never read again do not count as uses of their
+// right-hand side, so chains of dead stores disappear together.
+type liveness struct {
+	out  map[AST.Node]liveSet
+	refs map[AST.Node]liveSet
+}
+
+func analyzeLiveness(root *AST.BlockNode) *liveness {
+	l := &liveness{out: make(map[AST.Node]liveSet), refs: make(map[AST.Node]liveSet)}
+	l.block(root, liveSet{})
+	return l
+}
+
+func tokenUses(t AST.Token) []string {
+	if t.Type() == AST.T_IDENT {
+		return []string{t.(*AST.IdentToken).Name}
+	}
+	return nil
+}
+
+func (l *liveness) block(b *AST.BlockNode, out liveSet) liveSet {
+	live := out
+	for i := len(b.Nodes) - 1; i >= 0; i-- {
+		live = l.node(b.Nodes[i], live)
+	}
+	return live
+}
+
+// loop iterates a loop body to a fixed point; the condition is read before
+// every iteration and once more on exit
+func (l *liveness) loop(id string, body *AST.BlockNode, out liveSet) liveSet {
+	live := out.with(id)
+	for {
+		next := out.with(id).union(l.block(body, live))
+		if next.equals(live) {
+			return live
+		}
+		live = next
+	}
+}
+
+// node returns the variables live before n given those live after it
+func (l *liveness) node(n AST.Node, out liveSet) liveSet {
+	l.out[n] = out
+
+	switch n.Type() {
+	case AST.N_BLOCK:
+		b := n.(*AST.BlockNode)
+		in := l.block(b, out)
+		l.refs[n] = l.blockRefs(b)
+		return in
+	case AST.N_ASSIGN:
+		a := n.(*AST.AssignNode)
+		l.refs[n] = liveSet{}.with(append(tokenUses(a.Right), a.Left.Name)...)
+		if !out[a.Left.Name] {
+			return out
+		}
+		return out.without(a.Left.Name).with(tokenUses(a.Right)...)
+	case AST.N_ADD:
+		a := n.(*AST.AddNode)
+		l.refs[n] = liveSet{}.with(append(tokenUses(a.Right), a.Left.Name)...)
+		if !out[a.Left.Name] {
+			return out
+		}
+		return out.with(tokenUses(a.Right)...)
+	case AST.N_SUB:
+		s := n.(*AST.SubNode)
+		l.refs[n] = liveSet{}.with(append(tokenUses(s.Right), s.Left.Name)...)
+		if !out[s.Left.Name] {
+			return out
+		}
+		return out.with(tokenUses(s.Right)...)
+	case AST.N_IF:
+		i := n.(*AST.IfNode)
+		in := out.union(l.block(&i.Block, out)).with(i.Id.Name)
+		l.refs[n] = l.blockRefs(&i.Block).with(i.Id.Name)
+		return in
+	case AST.N_IFNOT:
+		i := n.(*AST.IfNotNode)
+		in := out.union(l.block(&i.Block, out)).with(i.Id.Name)
+		l.refs[n] = l.blockRefs(&i.Block).with(i.Id.Name)
+		return in
+	case AST.N_WHILE:
+		w := n.(*AST.WhileNode)
+		in := l.loop(w.Id.Name, &w.Block, out)
+		l.refs[n] = l.blockRefs(&w.Block).with(w.Id.Name)
+		return in
+	case AST.N_WHILENOT:
+		w := n.(*AST.WhileNotNode)
+		in := l.loop(w.Id.Name, &w.Block, out)
+		l.refs[n] = l.blockRefs(&w.Block).with(w.Id.Name)
+		return in
+	case AST.N_WRITE:
+		w := n.(*AST.WriteNode)
+		l.refs[n] = liveSet{}.with(tokenUses(w.Value)...)
+		return out.with(tokenUses(w.Value)...)
+	case AST.N_READ:
+		// At the end of input a read may leave the old value in place, so it
+		// does not kill the variable
+		r := n.(*AST.ReadNode)
+		l.refs[n] = liveSet{}.with(r.Value.Name)
+		return out.with(r.Value.Name)
+	case AST.N_FREE:
+		f := n.(*AST.FreeNode)
+		l.refs[n] = liveSet{}
+		return out.without(f.Value.Name)
+	case AST.N_ASM:
+		a := n.(*AST.AsmNode)
+		names := []string{}
+		for _, binding := range a.Bindings {
+			names = append(names, binding.Name)
+		}
+		l.refs[n] = liveSet{}.with(names...)
+		return out.with(names...)
+	}
+
+	l.refs[n] = liveSet{}
+	return out
+}
+
+func (l *liveness) blockRefs(b *AST.BlockNode) liveSet {
+	refs := liveSet{}
+	for _, child := range b.Nodes {
+		refs = refs.union(l.refs[child])
+	}
+	return refs
+}
+
+// dead reports whether the value of name is not read again after n. A store
+// to a dead variable can be skipped and a dead operand can be consumed.
+func (l *liveness) dead(n AST.Node, name string) bool {
+	return l != nil && !l.out[n][name]
+}
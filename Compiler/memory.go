@@ -1,20 +1,8 @@
 package Compiler
 
 import (
-	"strings"
-)
-
-const (
-	BF_INC        = "+"
-	BF_DEC        = "-"
-	BF_PTR_L      = "<"
-	BF_PTR_R      = ">"
-	BF_WRITE      = "."
-	BF_READ       = ","
-	BF_OPEN       = "["
-	BF_CLOSE      = "]"
-	BF_CLEAR      = "[-]"
-	BF_BREAKPOINT = "!"
+	"maps"
+	"slices"
 )
 
 type MemoryManager struct {
@@ -22,8 +10,20 @@ type MemoryManager struct {
 	Variables   map[string]int
 	FreedMemory []int
 	NextLoc     int
+	Allocations []Allocation // Every cell a variable has been given, in order
+
+	// Layout pins variables to planned cells; when set, temps are placed in the
+	// free cells nearest to where they are needed instead of first-come
+	Layout   map[string]int
+	reserved map[int]bool
+	dirty    map[int]bool // Planned cells released without being cleared
+
+	loopDepth int
+}
 
-	pointer int
+type Allocation struct {
+	Name string
+	Loc  int
 }
 
 func NewMemoryManager() *MemoryManager {
@@ -32,18 +32,72 @@ func NewMemoryManager() *MemoryManager {
 		Variables:   make(map[string]int),
 		FreedMemory: make([]int, 0),
 		NextLoc:     0,
-		pointer:     0,
 	}
 }
 
-func (m *MemoryManager) GetMemoryLoc(name string) (int, string) {
+// GetMemoryLoc returns the cell of a variable, allocating one if needed. The
+// second result reports that the cell still holds a stale value and must be
+// cleared before use.
+func (m *MemoryManager) GetMemoryLoc(name string) (int, bool) {
 	if loc, ok := m.Variables[name]; ok {
-		return loc, m.MovePointer(loc)
+		return loc, false
+	}
+	loc, planned := m.Layout[name]
+	if !planned && m.loopDepth > 0 {
+		// Earlier code in the loop body may already have used a freed cell as a
+		// temp, and would find this variable there on the next iteration
+		loc = m.NextLoc
+		m.NextLoc++
+	} else if !planned {
+		loc = m.getNextLoc()
 	}
-	loc := m.getNextLoc()
 	m.Variables[name] = loc
 	m.UsedMemory[loc] = true
-	return loc, m.MovePointer(loc)
+	m.Allocations = append(m.Allocations, Allocation{Name: name, Loc: loc})
+	if m.dirty[loc] {
+		delete(m.dirty, loc)
+		return loc, true
+	}
+	return loc, false
+}
+
+// SetLayout pins variables to cells, reserving those cells so temps avoid them
+func (m *MemoryManager) SetLayout(layout map[string]int) {
+	m.Layout = layout
+	m.reserved = make(map[int]bool, len(layout))
+	m.dirty = make(map[int]bool)
+	for _, loc := range layout {
+		m.reserved[loc] = true
+	}
+}
+
+// EnterLoop and ExitLoop bracket the allocations made while compiling a loop body
+func (m *MemoryManager) EnterLoop() {
+	m.loopDepth++
+}
+
+func (m *MemoryManager) ExitLoop() {
+	m.loopDepth--
+}
+
+// Snapshot copies the current variable table
+func (m *MemoryManager) Snapshot() map[string]int {
+	res := make(map[string]int, len(m.Variables))
+	for name, loc := range m.Variables {
+		res[name] = loc
+	}
+	return res
+}
+
+// clone copies the manager so it can be restored after a failed compile
+func (m *MemoryManager) clone() *MemoryManager {
+	res := *m
+	res.UsedMemory = maps.Clone(m.UsedMemory)
+	res.Variables = maps.Clone(m.Variables)
+	res.FreedMemory = slices.Clone(m.FreedMemory)
+	res.Allocations = slices.Clone(m.Allocations)
+	res.dirty = maps.Clone(m.dirty)
+	return &res
 }
 
 func (m *MemoryManager) IdentifierExists(name string) bool {
@@ -51,44 +105,69 @@ func (m *MemoryManager) IdentifierExists(name string) bool {
 	return ok
 }
 
-func (m *MemoryManager) GetClearLoc(name string) (int, string) {
-	loc, code := m.GetMemoryLoc(name)
-	return loc, code + BF_CLEAR
+func (m *MemoryManager) GetTempLoc() int {
+	loc := m.getNextLoc()
+	m.UsedMemory[loc] = true
+	return loc
 }
 
-func (m *MemoryManager) GetTempLoc() (int, string) {
-	loc := m.getNextLoc()
+// GetTempLocNear returns a temp cell as close to near as possible when a layout
+// is in use, and behaves like GetTempLoc otherwise
+func (m *MemoryManager) GetTempLocNear(near int) int {
+	if m.Layout == nil {
+		return m.GetTempLoc()
+	}
+	loc := m.nearestFreeLoc(near)
 	m.UsedMemory[loc] = true
-	return loc, m.MovePointer(loc)
+	return loc
 }
 
-func (m *MemoryManager) FreeMemoryLoc(name string) string {
+// PeekTempLocNear returns the cell GetTempLocNear would hand out, without allocating it
+func (m *MemoryManager) PeekTempLocNear(near int) int {
+	if m.Layout != nil {
+		return m.nearestFreeLoc(near)
+	}
+	if len(m.FreedMemory) > 0 {
+		return m.FreedMemory[0]
+	}
+	return m.NextLoc
+}
+
+// FreeMemoryLoc frees a variable and returns its cell, which must be cleared
+func (m *MemoryManager) FreeMemoryLoc(name string) int {
 	loc := m.Variables[name]
-	output := m.FreeTempLoc(loc)
+	m.FreeTempLoc(loc)
 	delete(m.Variables, name)
-	return output
+	return loc
 }
 
-func (m *MemoryManager) FreeTempLoc(loc int) string {
-	m.FreedMemory = append(m.FreedMemory, loc)
+// ReleaseMemoryLoc frees a variable like FreeMemoryLoc, but a planned cell is
+// only marked dirty since nothing else can use it; it is cleared if the
+// variable is ever allocated again. Reports whether the cell must be cleared now.
+func (m *MemoryManager) ReleaseMemoryLoc(name string) (int, bool) {
+	loc, planned := m.Layout[name]
+	if !planned {
+		return m.FreeMemoryLoc(name), true
+	}
+	m.dirty[loc] = true
 	m.UsedMemory[loc] = false
-	out := m.MovePointer(loc)
-	out += BF_CLEAR
-	return out
+	delete(m.Variables, name)
+	return loc, false
 }
 
-func (m *MemoryManager) MovePointer(pos int) string {
-	var out string
-	if m.pointer > pos {
-		out = strings.Repeat(BF_PTR_L, m.pointer-pos)
-	} else {
-		out = strings.Repeat(BF_PTR_R, pos-m.pointer)
+// FreeTempLoc returns a temp to the pool; the caller clears it. With a layout
+// free cells are found through UsedMemory, so there is no pool to keep.
+func (m *MemoryManager) FreeTempLoc(loc int) {
+	if m.Layout == nil {
+		m.FreedMemory = append(m.FreedMemory, loc)
 	}
-	m.pointer = pos
-	return out
+	m.UsedMemory[loc] = false
 }
 
 func (m *MemoryManager) getNextLoc() int {
+	if m.Layout != nil {
+		return m.nearestFreeLoc(0)
+	}
 	if len(m.FreedMemory) > 0 {
 		loc := m.FreedMemory[0]
 		m.FreedMemory = m.FreedMemory[1:]
@@ -98,3 +177,13 @@ func (m *MemoryManager) getNextLoc() int {
 	m.NextLoc++
 	return loc
 }
+
+func (m *MemoryManager) nearestFreeLoc(near int) int {
+	for d := 0; ; d++ {
+		for _, loc := range []int{near - d, near + d} {
+			if loc >= 0 && !m.reserved[loc] && !m.UsedMemory[loc] {
+				return loc
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package Compiler
+
+import (
+	"braining/AST"
+	"braining/Backend"
+	"braining/IR"
+	"braining/Logging"
+	"maps"
+	"strings"
+)
+
+// Session compiles a program one piece at a time for interactive use.
+// Variables, cells and the pointer carry over between pieces, so the code for
+// each piece runs on the tape left behind by the ones before it.
+type Session struct {
+	c       *Compiler
+	pointer int // Cell the Brainf*** pointer is on after the last piece
+}
+
+// NewSession starts an empty program. Dataflow optimization assumes it sees
+// the whole program, so sessions optimize at O_PEEPHOLE at most.
+func (c *Compiler) NewSession() *Session {
+	c.memoryManager = NewMemoryManager()
+	c.liveness = nil
+	c.declared = make(map[string]bool)
+	c.scopes = nil
+	c.pointer = 0
+	if c.OptLevel > IR.O_PEEPHOLE {
+		c.OptLevel = IR.O_PEEPHOLE
+	}
+	return &Session{c: c}
+}
+
+// Compile returns the Brainf*** for the next piece of the program. If it fails
+// the session is left as it was before the piece; the error is only returned
+// when the compiler's logger is recoverable, otherwise the process exits.
+func (s *Session) Compile(block *AST.BlockNode) (string, error) {
+	c := s.c
+	saved := c.memoryManager.clone()
+	declared := maps.Clone(c.declared)
+	c.scopes = nil
+	c.marks = nil
+	c.node = nil
+
+	code := &strings.Builder{}
+	emitter := Backend.NewBFEmitter(code, nil)
+	emitter.SetPointer(s.pointer)
+	c.sink = IR.NewOptimizer(c.OptLevel, c.target, emitter)
+
+	err := Logging.Catch(func() {
+		c.compileNode(block)
+	})
+	if err == nil {
+		err = c.sink.Close()
+	}
+	if err != nil {
+		c.memoryManager = saved
+		c.declared = declared
+		return "", err
+	}
+
+	c.Ast.Root.Nodes = append(c.Ast.Root.Nodes, block.Nodes...)
+	s.pointer = emitter.Pointer()
+	c.pointer = s.pointer
+	return code.String(), nil
+}
+
+// Variables returns the cell of every variable that currently exists
+func (s *Session) Variables() map[string]int {
+	return s.c.memoryManager.Snapshot()
+}
@@ -0,0 +1,56 @@
+package Compiler
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// countingWriter counts the bytes that reach the underlying writer
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+// WriteFile creates the file at path from what write writes. The output goes
+// to a temporary file in the same directory that only replaces path once write
+// has succeeded, so a failed compile leaves no empty or partial file behind.
+// The temporary file is also removed when write panics, as a recoverable
+// logger does on errors.
+func WriteFile(path string, perm os.FileMode, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	done := false
+	defer func() {
+		if !done {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if err := write(f); err != nil {
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	done = true
+	return nil
+}
@@ -0,0 +1,110 @@
+package Compiler
+
+import (
+	"braining/Backend"
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+const SOURCE_MAP_EXT = ".map"
+
+// SourceMap links ranges of the generated Brainf*** back to the statements
+// that produced them, and lists the cell every variable was placed in
+type SourceMap struct {
+	File      string          `json:"file,omitempty"`
+	Mappings  []SourceMapping `json:"mappings"`
+	Variables []VariableCell  `json:"variables"`
+}
+
+// SourceMapping covers the bytes of the output from Start up to (not
+// including) End. Those are instructions for plain Brainf***, but dialects
+// spell instructions with several bytes and annotations add comments. Lines
+// and columns are 1-based. Scope holds the cell of every variable that
+// exists when the statement starts.
+type SourceMapping struct {
+	Start   int            `json:"start"`
+	End     int            `json:"end"`
+	Line    int            `json:"line"`
+	Col     int            `json:"col"`
+	EndLine int            `json:"endLine"`
+	EndCol  int            `json:"endCol"`
+	Scope   map[string]int `json:"scope,omitempty"`
+}
+
+// VariableCell is one placement of a variable; a variable that is freed and
+// used again may appear more than once
+type VariableCell struct {
+	Name string `json:"name"`
+	Cell int    `json:"cell"`
+}
+
+// buildSourceMap turns the marks recorded by the emitter into ranges. Each mark
+// lasts until the next one, and empty ranges are dropped.
+func (c *Compiler) buildSourceMap(marks []Backend.Mark, length int) *SourceMap {
+	sm := &SourceMap{Mappings: []SourceMapping{}, Variables: []VariableCell{}}
+
+	for i, mark := range marks {
+		end := length
+		if i+1 < len(marks) {
+			end = marks[i+1].Before
+		}
+		if end <= mark.Offset {
+			continue
+		}
+
+		info := c.marks[mark.Id]
+		span := info.node.Position()
+		last := len(sm.Mappings) - 1
+		if last >= 0 && sm.Mappings[last].End == mark.Offset &&
+			sm.Mappings[last].Line == span.Start.Line && sm.Mappings[last].Col == span.Start.Col {
+			sm.Mappings[last].End = end
+			continue
+		}
+		sm.Mappings = append(sm.Mappings, SourceMapping{
+			Start:   mark.Offset,
+			End:     end,
+			Line:    span.Start.Line,
+			Col:     span.Start.Col,
+			EndLine: span.End.Line,
+			EndCol:  span.End.Col,
+			Scope:   info.scope,
+		})
+	}
+
+	seen := make(map[VariableCell]bool)
+	for _, a := range c.memoryManager.Allocations {
+		v := VariableCell{Name: a.Name, Cell: a.Loc}
+		if !seen[v] {
+			seen[v] = true
+			sm.Variables = append(sm.Variables, v)
+		}
+	}
+	return sm
+}
+
+// Lookup returns the mapping that covers an instruction offset
+func (sm *SourceMap) Lookup(offset int) (SourceMapping, bool) {
+	if i := sm.Index(offset); i >= 0 {
+		return sm.Mappings[i], true
+	}
+	return SourceMapping{}, false
+}
+
+// Index returns the position in Mappings of the mapping that covers an
+// instruction offset, or -1
+func (sm *SourceMap) Index(offset int) int {
+	i := sort.Search(len(sm.Mappings), func(i int) bool {
+		return sm.Mappings[i].End > offset
+	})
+	if i < len(sm.Mappings) && sm.Mappings[i].Start <= offset {
+		return i
+	}
+	return -1
+}
+
+func (sm *SourceMap) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sm)
+}
@@ -0,0 +1,325 @@
+package Debugger
+
+import (
+	"braining/Compiler"
+	"braining/Interpreter"
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const MEM_DUMP_WIDTH = 8 // Cells per line of a memory dump
+
+// Debugger runs a compiled program on the interpreter and stops it at source
+// statements. Positions come from the source map: every instruction is tied to
+// the statement that produced it, and a statement's scope says which cell each
+// variable lives in at that point.
+type Debugger struct {
+	machine     *Interpreter.Machine
+	sourceMap   *Compiler.SourceMap
+	lines       []string
+	mappingOf   []int // Mapping index of each instruction, or -1
+	depthOf     []int // Number of loops enclosing each instruction
+	breakpoints map[int]bool
+	watches     []string
+	hitBreak    bool
+	out         io.Writer
+}
+
+// NewDebugger prepares code for debugging. The program reads programIn and
+// writes programOut, separately from the debugger's own commands and output.
+func NewDebugger(code string, sourceMap *Compiler.SourceMap, source string, config Interpreter.Config, programIn io.Reader, programOut io.Writer) (*Debugger, error) {
+	program, err := Interpreter.Compile(code)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Debugger{
+		machine:     Interpreter.NewMachine(program, config, programIn, programOut),
+		sourceMap:   sourceMap,
+		lines:       strings.Split(source, "\n"),
+		mappingOf:   make([]int, len(program.Code)),
+		depthOf:     make([]int, len(program.Code)),
+		breakpoints: make(map[int]bool),
+	}
+	d.machine.OnBreakpoint = func(*Interpreter.Machine) {
+		d.hitBreak = true
+	}
+
+	depth := 0
+	for i, instr := range program.Code {
+		if instr.Op == Interpreter.I_CLOSE {
+			depth--
+		}
+		d.mappingOf[i] = sourceMap.Index(instr.Offset)
+		d.depthOf[i] = depth
+		if instr.Op == Interpreter.I_OPEN {
+			depth++
+		}
+	}
+	return d, nil
+}
+
+// current returns the mapping of the next instruction, or -1
+func (d *Debugger) current() int {
+	if d.machine.Done() {
+		return -1
+	}
+	return d.mappingOf[d.machine.PC]
+}
+
+func (d *Debugger) sameStatement(a, b int) bool {
+	if a < 0 || b < 0 {
+		return a == b
+	}
+	ma, mb := d.sourceMap.Mappings[a], d.sourceMap.Mappings[b]
+	return ma.Line == mb.Line && ma.Col == mb.Col
+}
+
+// resume runs until the program ends, hits a breakpoint, or arrives at a new
+// statement for which stop returns true
+func (d *Debugger) resume(stop func(mapping int) bool) error {
+	m := d.machine
+	defer m.Flush()
+	for !m.Done() {
+		prev := d.current()
+		if err := m.Step(); err != nil {
+			return err
+		}
+		if d.hitBreak {
+			d.hitBreak = false
+			fmt.Fprintln(d.out, "Breakpoint")
+			return nil
+		}
+		cur := d.current()
+		if cur < 0 || d.sameStatement(prev, cur) {
+			continue
+		}
+		if d.breakpoints[d.sourceMap.Mappings[cur].Line] {
+			fmt.Fprintf(d.out, "Breakpoint at line %d\n", d.sourceMap.Mappings[cur].Line)
+			return nil
+		}
+		if stop(cur) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Continue runs to the next breakpoint or the end
+func (d *Debugger) Continue() error {
+	return d.resume(func(int) bool { return false })
+}
+
+// Step runs to the next statement, entering loop and if bodies
+func (d *Debugger) Step() error {
+	return d.resume(func(int) bool { return true })
+}
+
+// Next runs to the next statement that is not nested inside the current one
+func (d *Debugger) Next() error {
+	if d.machine.Done() {
+		return nil
+	}
+	depth := d.depthOf[d.machine.PC]
+	return d.resume(func(int) bool {
+		return d.depthOf[d.machine.PC] <= depth
+	})
+}
+
+// Lookup returns the cell a variable lives in at the current statement
+func (d *Debugger) Lookup(name string) (int, bool) {
+	if strings.HasPrefix(name, "@") {
+		cell, err := strconv.Atoi(name[1:])
+		return cell, err == nil && cell >= 0 && cell < len(d.machine.Tape)
+	}
+	if i := d.current(); i >= 0 {
+		cell, ok := d.sourceMap.Mappings[i].Scope[name]
+		return cell, ok
+	}
+	// Past the end, fall back to the last cell the variable was given
+	for i := len(d.sourceMap.Variables) - 1; i >= 0; i-- {
+		if d.sourceMap.Variables[i].Name == name {
+			return d.sourceMap.Variables[i].Cell, true
+		}
+	}
+	return 0, false
+}
+
+func (d *Debugger) printValue(name string) {
+	cell, ok := d.Lookup(name)
+	if !ok {
+		fmt.Fprintf(d.out, "%s: not in scope\n", name)
+		return
+	}
+	v := d.machine.Value(cell)
+	fmt.Fprintf(d.out, "%s = %d", name, v)
+	if v >= 32 && v < 127 {
+		fmt.Fprintf(d.out, " '%c'", rune(v))
+	}
+	fmt.Fprintf(d.out, " (@%d)\n", cell)
+}
+
+// Where prints the statement about to run and the watch expressions
+func (d *Debugger) Where() {
+	i := d.current()
+	if i < 0 {
+		fmt.Fprintln(d.out, "Program finished")
+		return
+	}
+	line := d.sourceMap.Mappings[i].Line
+	fmt.Fprintf(d.out, "%4d | %s\n", line, d.sourceLine(line))
+	for _, w := range d.watches {
+		fmt.Fprint(d.out, "  watch ")
+		d.printValue(w)
+	}
+}
+
+func (d *Debugger) sourceLine(line int) string {
+	if line < 1 || line > len(d.lines) {
+		return ""
+	}
+	return strings.TrimRight(d.lines[line-1], "\r")
+}
+
+// Vars prints every variable in scope
+func (d *Debugger) Vars() {
+	i := d.current()
+	if i < 0 {
+		return
+	}
+	names := []string{}
+	for name := range d.sourceMap.Mappings[i].Scope {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		d.printValue(name)
+	}
+}
+
+// Mem dumps count cells from start, marking the pointer and naming cells
+// that hold variables
+func (d *Debugger) Mem(start, count int) {
+	names := map[int]string{}
+	if i := d.current(); i >= 0 {
+		for name, cell := range d.sourceMap.Mappings[i].Scope {
+			names[cell] = name
+		}
+	}
+	end := min(start+count, len(d.machine.Tape))
+	for cell := max(start, 0); cell < end; cell++ {
+		if (cell-start)%MEM_DUMP_WIDTH == 0 {
+			if cell != start {
+				fmt.Fprintln(d.out)
+			}
+			fmt.Fprintf(d.out, "%5d:", cell)
+		}
+		marker := " "
+		if cell == d.machine.Pointer {
+			marker = "*"
+		}
+		fmt.Fprintf(d.out, " %s%3d", marker, d.machine.Value(cell))
+		if name, ok := names[cell]; ok {
+			fmt.Fprintf(d.out, "(%s)", name)
+		}
+	}
+	fmt.Fprintln(d.out)
+}
+
+const HELP = `Commands:
+  run, continue, c     run to the next breakpoint or the end
+  step, s              run to the next statement, entering bodies
+  next, n              run to the next statement at the same nesting level
+  break LINE, b LINE   stop whenever LINE is reached
+  delete LINE          remove a line breakpoint
+  print NAME, p NAME   show a variable, or a cell written as @N
+  watch NAME           show NAME every time the program stops
+  unwatch NAME         stop watching NAME
+  vars                 show every variable in scope
+  mem [START [COUNT]]  dump the tape
+  where, w             show the current statement
+  quit, q              leave the debugger`
+
+// Run reads commands until quit or the end of commands, printing to out
+func (d *Debugger) Run(commands io.Reader, out io.Writer) error {
+	d.out = out
+	scanner := bufio.NewScanner(commands)
+
+	d.Where()
+	for {
+		fmt.Fprint(out, "(brdb) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		var err error
+		switch fields[0] {
+		case "run", "continue", "c":
+			err = d.Continue()
+			d.Where()
+		case "step", "s":
+			err = d.Step()
+			d.Where()
+		case "next", "n":
+			err = d.Next()
+			d.Where()
+		case "break", "b", "delete":
+			if len(fields) != 2 {
+				fmt.Fprintln(out, "usage: "+fields[0]+" LINE")
+				continue
+			}
+			line, convErr := strconv.Atoi(fields[1])
+			if convErr != nil {
+				fmt.Fprintln(out, "not a line number: "+fields[1])
+				continue
+			}
+			d.breakpoints[line] = fields[0] != "delete"
+		case "print", "p":
+			for _, name := range fields[1:] {
+				d.printValue(name)
+			}
+		case "watch":
+			d.watches = append(d.watches, fields[1:]...)
+		case "unwatch":
+			for _, name := range fields[1:] {
+				for i, w := range d.watches {
+					if w == name {
+						d.watches = append(d.watches[:i], d.watches[i+1:]...)
+						break
+					}
+				}
+			}
+		case "vars":
+			d.Vars()
+		case "mem":
+			start, count := 0, 4*MEM_DUMP_WIDTH
+			if len(fields) > 1 {
+				start, _ = strconv.Atoi(fields[1])
+			}
+			if len(fields) > 2 {
+				count, _ = strconv.Atoi(fields[2])
+			}
+			d.Mem(start, count)
+		case "where", "w":
+			d.Where()
+		case "help", "h":
+			fmt.Fprintln(out, HELP)
+		case "quit", "q":
+			return d.machine.Flush()
+		default:
+			fmt.Fprintln(out, "unknown command "+fields[0]+", try help")
+		}
+		if err != nil {
+			fmt.Fprintln(out, err.Error())
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package Debugger_test
+
+import (
+	"braining/Compiler"
+	"braining/Debugger"
+	"braining/IR"
+	"braining/Interpreter"
+	"braining/Logging"
+	"braining/Parser"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const SOURCE = `x = 2
+y = 65
+while x
+  y += 1
+  x -= 1
+end
+write y
+`
+
+// session debugs SOURCE, compiled without optimization so cells are easy to
+// predict, and returns the transcript of commands and what the program wrote
+func session(t *testing.T, commands string) (string, string) {
+	t.Helper()
+	var c *Compiler.Compiler
+	err := Logging.Catch(func() {
+		a := Parser.NewParser(SOURCE, Logging.NewRecoverableLogger("braining_parser")).Parse()
+		c = Compiler.NewCompiler(a, IR.DefaultTarget(), Logging.NewRecoverableLogger("braining_compiler"))
+		c.Source = SOURCE
+		c.CollectCode = true
+		if err := c.CompileTo(nil); err != nil {
+			panic(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	programOut := bytes.Buffer{}
+	d, err := Debugger.NewDebugger(c.Code, c.SourceMap, SOURCE, Interpreter.DefaultConfig(), strings.NewReader(""), &programOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transcript := strings.Builder{}
+	if err := d.Run(strings.NewReader(commands), &transcript); err != nil {
+		t.Fatal(err)
+	}
+	return transcript.String(), programOut.String()
+}
+
+func TestSession(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands string
+		want     []string // Lines the transcript shows, in order
+		written  string
+	}{
+		{
+			"step",
+			"step\nstep\nstep\nstep\nprint x y\n",
+			[]string{"   2 | y = 65", "   3 | while x", "   4 |   y += 1", "   5 |   x -= 1", "x = 2 (@0)", "y = 66 'B' (@1)"},
+			"",
+		},
+		{
+			"breakpoint",
+			"break 5\ncontinue\nprint x\ncontinue\nprint x\ndelete 5\ncontinue\n",
+			[]string{"Breakpoint at line 5", "x = 2 (@0)", "Breakpoint at line 5", "x = 1 (@0)", "Program finished"},
+			"C",
+		},
+		{
+			"next skips bodies",
+			"next\nnext\nnext\nnext\nnext\nprint y\n",
+			[]string{"   2 | y = 65", "   3 | while x", "   3 | while x", "   3 | while x", "   7 | write y", "y = 67 'C' (@1)"},
+			"",
+		},
+		{
+			"watch",
+			"watch x\nstep\nunwatch x\nstep\n",
+			[]string{"   2 | y = 65", "  watch x = 2 (@0)", "   3 | while x"},
+			"",
+		},
+		{
+			"cells and memory",
+			"b 7\nc\np @1 z\nmem 0 2\nvars\n",
+			[]string{"@1 = 67 'C' (@1)", "z: not in scope", "    0: *  0(x)   67(y)", "x = 0 (@0)", "y = 67 'C' (@1)"},
+			"",
+		},
+		{
+			"bad commands",
+			"break\nbreak x\nfly\nquit\ncontinue\n",
+			[]string{"usage: break LINE", "not a line number: x", "unknown command fly, try help"},
+			"",
+		},
+	}
+	for _, tt := range tests {
+		transcript, written := session(t, tt.commands)
+		rest := strings.Split(strings.ReplaceAll(transcript, "(brdb) ", ""), "\n")
+		for _, want := range tt.want {
+			i := 0
+			for i < len(rest) && rest[i] != want {
+				i++
+			}
+			if i == len(rest) {
+				t.Errorf("%s: no %q in order in\n%s", tt.name, want, transcript)
+				break
+			}
+			rest = rest[i+1:]
+		}
+		if written != tt.written {
+			t.Errorf("%s: program wrote %q, want %q", tt.name, written, tt.written)
+		}
+	}
+}
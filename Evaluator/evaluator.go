@@ -0,0 +1,202 @@
+package Evaluator
+
+import (
+	"braining/AST"
+	"braining/IR"
+	"braining/Interpreter"
+	"braining/Logging"
+	"bufio"
+	"io"
+	"strconv"
+)
+
+// Evaluator runs a program straight from its AST, as the reference for what
+// compiled code must do. Cells hold CellBits wide values as on the
+// interpreter, subtraction stops at zero on saturating targets, and addition
+// past the largest value fails on targets that do not wrap. A
+// variable that has not been set, or has been freed, reads as zero; which
+// names may be used where is checked by the compiler, not here. Asm depends on
+// where the compiler places variables, so it has no reference meaning and is
+// rejected.
+type Evaluator struct {
+	Target    *IR.Target
+	EOF       Interpreter.EOFMode
+	MaxSteps  int // Statements and loop tests a run may execute; 0 means no limit
+	Steps     int
+	Variables map[string]uint32
+
+	in   *bufio.Reader
+	out  *bufio.Writer
+	mask uint32
+}
+
+// NewEvaluator prepares an empty set of variables. A nil target means the
+// default target.
+func NewEvaluator(target *IR.Target, in io.Reader, out io.Writer) *Evaluator {
+	if target == nil {
+		target = IR.DefaultTarget()
+	}
+	return &Evaluator{
+		Target:    target,
+		Variables: make(map[string]uint32),
+		in:        bufio.NewReader(in),
+		out:       bufio.NewWriter(out),
+		mask:      uint32(uint64(1)<<target.CellBits - 1),
+	}
+}
+
+// Run evaluates the program, then flushes output
+func (e *Evaluator) Run(a *AST.Ast) error {
+	err := e.block(&a.Root)
+	if flushErr := e.out.Flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+func (e *Evaluator) step() error {
+	e.Steps++
+	if e.MaxSteps > 0 && e.Steps > e.MaxSteps {
+		return &Logging.StepLimitEvaluatorError{Limit: e.MaxSteps}
+	}
+	return nil
+}
+
+func (e *Evaluator) block(b *AST.BlockNode) error {
+	for _, n := range b.Nodes {
+		if err := e.node(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Evaluator) node(node AST.Node) error {
+	if node.Type() != AST.N_BLOCK && node.Type() != AST.N_MACRO {
+		if err := e.step(); err != nil {
+			return err
+		}
+	}
+
+	switch node.Type() {
+	case AST.N_BLOCK:
+		return e.block(node.(*AST.BlockNode))
+
+	case AST.N_ASSIGN:
+		n := node.(*AST.AssignNode)
+		val, err := e.value(n.Right)
+		if err != nil {
+			return err
+		}
+		e.Variables[n.Left.Name] = val
+
+	case AST.N_ADD:
+		n := node.(*AST.AddNode)
+		val, err := e.value(n.Right)
+		if err != nil {
+			return err
+		}
+		sum := uint64(e.Variables[n.Left.Name]) + uint64(val)
+		if !e.Target.Wrapping && sum > uint64(e.mask) {
+			return &Logging.OverflowEvaluatorError{Name: n.Left.Name}
+		}
+		e.Variables[n.Left.Name] = uint32(sum) & e.mask
+
+	case AST.N_SUB:
+		n := node.(*AST.SubNode)
+		val, err := e.value(n.Right)
+		if err != nil {
+			return err
+		}
+		left := e.Variables[n.Left.Name]
+		if e.Target.Saturating() && val > left {
+			val = left
+		}
+		e.Variables[n.Left.Name] = (left - val) & e.mask
+
+	case AST.N_IF:
+		n := node.(*AST.IfNode)
+		if e.Variables[n.Id.Name] != 0 {
+			return e.block(&n.Block)
+		}
+
+	case AST.N_IFNOT:
+		n := node.(*AST.IfNotNode)
+		if e.Variables[n.Id.Name] == 0 {
+			return e.block(&n.Block)
+		}
+
+	case AST.N_WHILE:
+		n := node.(*AST.WhileNode)
+		for e.Variables[n.Id.Name] != 0 {
+			if err := e.block(&n.Block); err != nil {
+				return err
+			}
+			if err := e.step(); err != nil {
+				return err
+			}
+		}
+
+	case AST.N_WHILENOT:
+		n := node.(*AST.WhileNotNode)
+		for e.Variables[n.Id.Name] == 0 {
+			if err := e.block(&n.Block); err != nil {
+				return err
+			}
+			if err := e.step(); err != nil {
+				return err
+			}
+		}
+
+	case AST.N_WRITE:
+		val, err := e.value(node.(*AST.WriteNode).Value)
+		if err != nil {
+			return err
+		}
+		return e.out.WriteByte(byte(val))
+
+	case AST.N_READ:
+		return e.read(node.(*AST.ReadNode).Value.Name)
+
+	case AST.N_FREE:
+		delete(e.Variables, node.(*AST.FreeNode).Value.Name)
+
+	case AST.N_ASM:
+		return &Logging.UnsupportedEvaluatorError{Statement: "asm"}
+	}
+	return nil
+}
+
+// read takes a byte of input, flushing output first so prompts show
+func (e *Evaluator) read(name string) error {
+	if err := e.out.Flush(); err != nil {
+		return err
+	}
+	b, err := e.in.ReadByte()
+	if err == io.EOF {
+		switch e.EOF {
+		case Interpreter.EOF_ZERO:
+			e.Variables[name] = 0
+		case Interpreter.EOF_MAX:
+			e.Variables[name] = e.mask
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	e.Variables[name] = uint32(b)
+	return nil
+}
+
+func (e *Evaluator) value(t AST.Token) (uint32, error) {
+	if t.Type() == AST.T_IDENT {
+		return e.Variables[t.(*AST.IdentToken).Name], nil
+	}
+	lit := t.(*AST.LitToken)
+	val, err := strconv.Atoi(lit.Value)
+	if err != nil || val < 0 || val > e.Target.MaxLiteral() {
+		return 0, &Logging.InvalidLiteralEvaluatorError{Value: lit.Value}
+	}
+	return uint32(val), nil
+}
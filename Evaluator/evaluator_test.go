@@ -0,0 +1,82 @@
+package Evaluator_test
+
+import (
+	"braining/AST"
+	"braining/Evaluator"
+	"braining/IR"
+	"braining/Interpreter"
+	"braining/Logging"
+	"braining/Parser"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	wrapping := IR.DefaultTarget()
+	saturating := &IR.Target{CellBits: 8}
+	tests := []struct {
+		name   string
+		target *IR.Target
+		src    string
+		input  string
+		want   string
+		err    error // Of the type expected, or nil
+	}{
+		{"arithmetic", wrapping, "x = 70\nx += 5\nx -= 3\nwrite x\n", "", "H", nil},
+		{"wrapping", wrapping, "x = 250\nx += 10\nwrite x\ny = 0\ny -= 1\nwrite y\n", "", "\x04\xff", nil},
+		{"saturating", saturating, "x = 3\nx -= 10\nwrite x\n", "", "\x00", nil},
+		{"overflow", saturating, "x = 'a'\nwrite x\nx += 200\nwrite x\n", "", "a", &Logging.OverflowEvaluatorError{}},
+		{"loops", wrapping, "c = 3\nx = 'a'\nwhile c\n  write x\n  x += 1\n  c -= 1\nend\nwhile not c\n  c = 1\nend\nwrite c\n", "", "abc\x01", nil},
+		{"branches", wrapping, "x = 0\nif x\n  write 'y'\nend\nif not x\n  write 'n'\nend\n", "", "n", nil},
+		{"macros", wrapping, "macro twice takes a define\na += a\nemcro\nx = '!'\ncall twice x\nwrite x\n", "", "B", nil},
+		{"input", wrapping, "x = 0\nread x\nwrite x\nread x\nwrite x\n", "q", "qq", nil},
+		{"free", wrapping, "x = 5\nfree x\ny = 0\ny += x\nwrite y\n", "", "\x00", nil},
+		{"asm", wrapping, "x = 1\nasm x define\n+\nendasm\n", "", "", &Logging.UnsupportedEvaluatorError{}},
+		{"steps", wrapping, "x = 1\nwhile x\n  x = 1\nend\n", "", "", &Logging.StepLimitEvaluatorError{}},
+	}
+	for _, tt := range tests {
+		var a AST.Ast
+		if err := Logging.Catch(func() {
+			a = Parser.NewParser(tt.src, Logging.NewRecoverableLogger("test")).Parse()
+		}); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		out := bytes.Buffer{}
+		e := Evaluator.NewEvaluator(tt.target, strings.NewReader(tt.input), &out)
+		e.MaxSteps = 1000
+		err := e.Run(&a)
+		if tt.err == nil && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if reflect.TypeOf(err) != reflect.TypeOf(tt.err) {
+			t.Errorf("%s: got %v, want %T", tt.name, err, tt.err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s: wrote %q, want %q", tt.name, out.String(), tt.want)
+		}
+	}
+}
+
+func TestEOF(t *testing.T) {
+	tests := []struct {
+		mode Interpreter.EOFMode
+		want string
+	}{
+		{Interpreter.EOF_UNCHANGED, "\x07"},
+		{Interpreter.EOF_ZERO, "\x00"},
+		{Interpreter.EOF_MAX, "\xff"},
+	}
+	a := Parser.NewParser("x = 7\nread x\nwrite x\n", nil).Parse()
+	for _, tt := range tests {
+		out := bytes.Buffer{}
+		e := Evaluator.NewEvaluator(nil, strings.NewReader(""), &out)
+		e.EOF = tt.mode
+		if err := e.Run(&a); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("mode %d: wrote %q, want %q", tt.mode, out.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package Formatter
+
+import (
+	"braining/AST"
+	"braining/Logging"
+	"braining/Parser"
+	"errors"
+	"sort"
+	"strings"
+)
+
+const INDENT = "  "
+
+// Format parses source and prints it in the canonical layout: one statement
+// per line, indented by nesting, with single spaces between tokens. Comments
+// and directives are kept where they were, single blank lines between
+// statements are kept, and asm bodies and anything after an explicit done are
+// left exactly as written. Every #if branch is formatted in place, so each has
+// to hold whole statements.
+func Format(source string) (string, error) {
+	var a AST.Ast
+	err := Logging.Catch(func() {
+		p := Parser.NewParser(source, Logging.NewRecoverableLogger("braining_formatter"))
+		p.KeepTrivia()
+		a = p.Parse()
+	})
+	if err != nil {
+		return "", branchesError(source, err)
+	}
+
+	p := &printer{comments: a.Comments, tokens: a.Tokens, macros: make(map[string][]AST.IdentToken)}
+	p.block(&a.Root, 0)
+	if a.Done == nil {
+		p.commentsBefore(AST.Pos{Line: len(source) + 1}, 0)
+		return p.String(), nil
+	}
+	p.commentsBefore(*a.Done, 0)
+	p.emit(0, "done"+rest(source, *a.Done, len("done")), a.Done.Line, a.Done.Line)
+	return strings.TrimRight(p.String(), " \t\r\n") + "\n", nil
+}
+
+// branchesError explains a program that fails to parse with every #if branch
+// included but parses as it is compiled, which can only be down to its
+// branches
+func branchesError(source string, err error) error {
+	if Logging.Catch(func() {
+		Parser.NewParser(source, Logging.NewRecoverableLogger("braining_formatter")).Parse()
+	}) != nil {
+		return err
+	}
+	res := &Logging.SourceError{Err: &Logging.BranchesFormatterError{Err: err}}
+	var srcErr *Logging.SourceError
+	if errors.As(err, &srcErr) {
+		res.Err = &Logging.BranchesFormatterError{Err: srcErr.Err}
+		res.Line, res.Col, res.EndLine, res.EndCol = srcErr.Line, srcErr.Col, srcErr.EndLine, srcErr.EndCol
+	}
+	return res
+}
+
+type printer struct {
+	lines    []string
+	comments []AST.Comment
+	tokens   []AST.Pos
+	next     int  // Next comment to print
+	lastLine int  // Source line the last printed text ended on, 0 at the start
+	opened   bool // Nothing has been printed in the current body yet
+	macros   map[string][]AST.IdentToken
+}
+
+func (p *printer) String() string {
+	if len(p.lines) == 0 {
+		return ""
+	}
+	return strings.Join(p.lines, "\n") + "\n"
+}
+
+// emit prints text that spans source lines start to end on a line of its own,
+// after a blank line if the source had at least one there
+func (p *printer) emit(depth int, text string, start, end int) {
+	if p.lastLine > 0 && start > p.lastLine+1 && !p.opened {
+		p.lines = append(p.lines, "")
+	}
+	p.lines = append(p.lines, strings.Repeat(INDENT, depth)+text)
+	p.lastLine = end
+	p.opened = false
+}
+
+// closing prints the end of a body; blank lines before it are dropped
+func (p *printer) closing(depth int, text string, line int) {
+	p.opened = true
+	p.emit(depth, text, line, line)
+}
+
+// commentsBefore prints the comments that start before pos. A comment on the
+// same line as the code before it stays at the end of that line.
+func (p *printer) commentsBefore(pos AST.Pos, depth int) {
+	for ; p.next < len(p.comments); p.next++ {
+		c := p.comments[p.next]
+		if !before(c.Start, pos) {
+			return
+		}
+		if p.lastLine > 0 && c.Start.Line == p.lastLine && len(p.lines) > 0 {
+			p.lines[len(p.lines)-1] += " " + c.Text
+			p.lastLine = c.End.Line
+			continue
+		}
+		p.emit(depth, c.Text, c.Start.Line, c.End.Line)
+	}
+}
+
+func before(a, b AST.Pos) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Col < b.Col
+}
+
+func (p *printer) block(b *AST.BlockNode, depth int) {
+	for _, n := range b.Nodes {
+		p.node(n, depth)
+	}
+}
+
+// body prints the statements of an if, while or macro and its closing keyword
+func (p *printer) body(b *AST.BlockNode, depth int, closing string) {
+	p.opened = true
+	p.block(b, depth+1)
+	p.commentsBefore(b.End, depth+1)
+	p.closing(depth, closing, b.End.Line)
+}
+
+func token(t AST.Token) string {
+	switch t.Type() {
+	case AST.T_IDENT:
+		return t.(*AST.IdentToken).Name
+	case AST.T_LIT:
+		lit := t.(*AST.LitToken)
+		if lit.Text != "" {
+			return lit.Text
+		}
+		return lit.Value
+	}
+	return ""
+}
+
+func names(idents []AST.IdentToken) []string {
+	res := make([]string, len(idents))
+	for i, id := range idents {
+		res[i] = id.Name
+	}
+	return res
+}
+
+// statement prints the words of the statement at span on a line of its own,
+// one word per token of the source. Comments between its first and last token
+// stay between the same words.
+func (p *printer) statement(depth int, span AST.Span, words []string) {
+	first := sort.Search(len(p.tokens), func(i int) bool { return !before(p.tokens[i], span.Start) })
+	var sb strings.Builder
+	for i, w := range words {
+		if i > 0 {
+			for ; first+i < len(p.tokens) && p.next < len(p.comments); p.next++ {
+				c := p.comments[p.next]
+				if !before(c.Start, p.tokens[first+i]) {
+					break
+				}
+				sb.WriteString(" " + c.Text)
+			}
+			sb.WriteString(" ")
+		}
+		sb.WriteString(w)
+	}
+	p.emit(depth, sb.String(), span.Start.Line, span.End.Line)
+}
+
+func (p *printer) node(n AST.Node, depth int) {
+	span := n.Position()
+	p.commentsBefore(span.Start, depth)
+	line := func(words ...string) {
+		p.statement(depth, span, words)
+	}
+
+	switch n.Type() {
+	case AST.N_BLOCK:
+		b := n.(*AST.BlockNode)
+		if b.Call == nil {
+			p.block(b, depth)
+			return
+		}
+		words := []string{"call", b.Call.Name.Name}
+		for _, param := range p.macros[b.Call.Name.Name] {
+			words = append(words, token(b.Call.Args[param.Name]))
+		}
+		line(words...)
+	case AST.N_ASSIGN:
+		a := n.(*AST.AssignNode)
+		line(a.Left.Name, "=", token(a.Right))
+	case AST.N_ADD:
+		a := n.(*AST.AddNode)
+		line(a.Left.Name, "+=", token(a.Right))
+	case AST.N_SUB:
+		s := n.(*AST.SubNode)
+		line(s.Left.Name, "-=", token(s.Right))
+	case AST.N_IF:
+		i := n.(*AST.IfNode)
+		line("if", i.Id.Name)
+		p.body(&i.Block, depth, "end")
+	case AST.N_IFNOT:
+		i := n.(*AST.IfNotNode)
+		line("if", "not", i.Id.Name)
+		p.body(&i.Block, depth, "end")
+	case AST.N_WHILE:
+		w := n.(*AST.WhileNode)
+		line("while", w.Id.Name)
+		p.body(&w.Block, depth, "end")
+	case AST.N_WHILENOT:
+		w := n.(*AST.WhileNotNode)
+		line("while", "not", w.Id.Name)
+		p.body(&w.Block, depth, "end")
+	case AST.N_WRITE:
+		line("write", token(n.(*AST.WriteNode).Value))
+	case AST.N_READ:
+		line("read", n.(*AST.ReadNode).Value.Name)
+	case AST.N_FREE:
+		line("free", n.(*AST.FreeNode).Value.Name)
+	case AST.N_BREAKPOINT:
+		line("breakpoint")
+	case AST.N_MACRO:
+		m := n.(*AST.MacroNode)
+		p.macros[m.Name.Name] = m.Params
+		words := append([]string{"macro", m.Name.Name, "takes"}, names(m.Params)...)
+		line(append(words, "define")...)
+		p.body(&m.Block, depth, "emcro")
+	case AST.N_ASM:
+		a := n.(*AST.AsmNode)
+		words := append([]string{"asm"}, names(a.Bindings)...)
+		line(append(words, "define"+a.Raw+"endasm")...)
+	}
+}
+
+// rest returns the source after the n characters at pos
+func rest(source string, pos AST.Pos, n int) string {
+	lines := strings.SplitAfter(source, "\n")
+	if pos.Line < 1 || pos.Line > len(lines) {
+		return ""
+	}
+	first := []rune(lines[pos.Line-1])
+	start := min(pos.Col-1+n, len(first))
+	return string(first[start:]) + strings.Join(lines[pos.Line:], "")
+}
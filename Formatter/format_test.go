@@ -0,0 +1,88 @@
+package Formatter_test
+
+import (
+	"braining/Formatter"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			"layout",
+			"x   =  1\nwhile x\nx -= 1\n\n\nwrite   x\nend\n",
+			"x = 1\nwhile x\n  x -= 1\n\n  write x\nend\n",
+		},
+		{
+			"comments between tokens",
+			"y | target | = | value | 3\nwrite  y   | trailing |\n",
+			"y | target | = | value | 3\nwrite y | trailing |\n",
+		},
+		{
+			"comments before a closing keyword",
+			"if x\nwrite x\n  | last |\nend\n",
+			"if x\n  write x\n  | last |\nend\n",
+		},
+		{
+			"comments around branches",
+			"| before |\n#if DEBUG\n  | in the branch |\nwrite   1\n#else\nwrite 2 | other |\n#end\n| after |\n",
+			"| before |\n#if DEBUG\n| in the branch |\nwrite 1\n#else\nwrite 2 | other |\n#end\n| after |\n",
+		},
+		{
+			"macros",
+			"macro   m takes a   b define\na += b\nemcro\ncall m x   'q'\n",
+			"macro m takes a b define\n  a += b\nemcro\ncall m x 'q'\n",
+		},
+		{
+			"asm bodies as written",
+			"asm   x y  define\n| move { x } |[-{ y }+{x}]  \nendasm\n",
+			"asm x y define\n| move { x } |[-{ y }+{x}]  \nendasm\n",
+		},
+		{
+			"after done",
+			"write  1\ndone   keep   this\n  as is\n",
+			"write 1\ndone   keep   this\n  as is\n",
+		},
+	}
+	for _, tt := range tests {
+		got, err := Formatter.Format(tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+		if again, err := Formatter.Format(got); err != nil || again != got {
+			t.Errorf("%s: formatting again gave %v\n%s", tt.name, err, again)
+		}
+	}
+}
+
+// TestFormatCorpus checks that formatting the programs under testdata is
+// idempotent
+func TestFormatCorpus(t *testing.T) {
+	paths, err := filepath.Glob("../testdata/*.br")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		once, err := Formatter.Format(string(src))
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if twice, err := Formatter.Format(once); err != nil || twice != once {
+			t.Errorf("%s: formatting again gave %v\n%s\nafter\n%s", path, err, twice, once)
+		}
+	}
+}
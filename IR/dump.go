@@ -0,0 +1,61 @@
+package IR
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Dumper is a sink that writes ops as indented text, one per line
+type Dumper struct {
+	w     *bufio.Writer
+	depth int
+}
+
+func NewDumper(w io.Writer) *Dumper {
+	return &Dumper{w: bufio.NewWriter(w)}
+}
+
+func (op Op) String() string {
+	switch op.Kind {
+	case OP_ADD:
+		return fmt.Sprintf("add @%d, %d", op.Loc, op.N)
+	case OP_CLEAR:
+		return fmt.Sprintf("clear @%d", op.Loc)
+	case OP_MOVE:
+		targets := make([]string, len(op.Targets))
+		for i, t := range op.Targets {
+			targets[i] = fmt.Sprintf("@%d*%d", t.Loc, t.Factor)
+		}
+		return fmt.Sprintf("move @%d -> %s", op.Loc, strings.Join(targets, ", "))
+	case OP_OPEN:
+		return fmt.Sprintf("loop @%d {", op.Loc)
+	case OP_CLOSE:
+		return fmt.Sprintf("} @%d", op.Loc)
+	case OP_IN:
+		return fmt.Sprintf("in @%d", op.Loc)
+	case OP_OUT:
+		return fmt.Sprintf("out @%d", op.Loc)
+	case OP_BREAKPOINT:
+		return "breakpoint"
+	case OP_MARK:
+		return fmt.Sprintf("mark %d", op.N)
+	default:
+		return "unknown"
+	}
+}
+
+func (d *Dumper) Emit(op Op) {
+	if op.Kind == OP_CLOSE {
+		d.depth--
+	}
+	d.w.WriteString(strings.Repeat("  ", d.depth) + op.String() + "\n")
+	if op.Kind == OP_OPEN {
+		d.depth++
+	}
+}
+
+func (d *Dumper) Close() error {
+	return d.w.Flush()
+}
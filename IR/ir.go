@@ -0,0 +1,92 @@
+package IR
+
+type OpKind int
+
+const (
+	OP_ADD        OpKind = iota // Cell[Loc] += N
+	OP_CLEAR                    // Cell[Loc] = 0
+	OP_MOVE                     // Cell[t.Loc] += Cell[Loc] * t.Factor for each target t, then Cell[Loc] = 0
+	OP_OPEN                     // Loop while Cell[Loc] != 0
+	OP_CLOSE                    // End of the loop opened on the same Loc
+	OP_IN                       // Cell[Loc] = next input byte
+	OP_OUT                      // Output Cell[Loc]
+	OP_BREAKPOINT               // Stop for the debugger
+	OP_MARK                     // Code from here on belongs to source statement N; has no effect on cells
+)
+
+type MoveTarget struct {
+	Loc    int
+	Factor int
+}
+
+// Op is a single cell-addressed instruction. Every op names the cell it works
+// on, so backends decide for themselves how to reach it.
+type Op struct {
+	Kind    OpKind
+	Loc     int
+	N       int
+	Targets []MoveTarget
+}
+
+// Sink consumes a stream of ops. Close is called once after the last op.
+type Sink interface {
+	Emit(op Op)
+	Close() error
+}
+
+// Program collects ops in memory
+type Program struct {
+	Ops []Op
+}
+
+func (p *Program) Emit(op Op) {
+	p.Ops = append(p.Ops, op)
+}
+
+func (p *Program) Close() error {
+	return nil
+}
+
+// Replay sends the collected ops into another sink and closes it
+func (p *Program) Replay(sink Sink) error {
+	for _, op := range p.Ops {
+		sink.Emit(op)
+	}
+	return sink.Close()
+}
+
+func Add(loc, n int) Op {
+	return Op{Kind: OP_ADD, Loc: loc, N: n}
+}
+
+func Clear(loc int) Op {
+	return Op{Kind: OP_CLEAR, Loc: loc}
+}
+
+func Move(from int, targets ...MoveTarget) Op {
+	return Op{Kind: OP_MOVE, Loc: from, Targets: targets}
+}
+
+func OpenLoop(loc int) Op {
+	return Op{Kind: OP_OPEN, Loc: loc}
+}
+
+func CloseLoop(loc int) Op {
+	return Op{Kind: OP_CLOSE, Loc: loc}
+}
+
+func In(loc int) Op {
+	return Op{Kind: OP_IN, Loc: loc}
+}
+
+func Out(loc int) Op {
+	return Op{Kind: OP_OUT, Loc: loc}
+}
+
+func Breakpoint() Op {
+	return Op{Kind: OP_BREAKPOINT}
+}
+
+func Mark(id int) Op {
+	return Op{Kind: OP_MARK, N: id}
+}
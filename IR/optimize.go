@@ -0,0 +1,186 @@
+package IR
+
+// Optimization levels
+const (
+	O_NONE     = iota // Emit ops as generated
+	O_PEEPHOLE        // Fold adds to the same cell and cancel inverse operations
+	O_DATAFLOW        // Also drop clears, moves and loops on cells known to be zero, and plan variable layout
+)
+
+// cellFacts records which cells are known to hold zero. With defaultZero set,
+// every cell is zero unless listed in except; otherwise only the listed cells are.
+type cellFacts struct {
+	defaultZero bool
+	except      map[int]bool
+}
+
+func (f *cellFacts) isZero(loc int) bool {
+	return f.defaultZero != f.except[loc]
+}
+
+func (f *cellFacts) set(loc int, zero bool) {
+	if zero == f.defaultZero {
+		delete(f.except, loc)
+	} else {
+		f.except[loc] = true
+	}
+}
+
+func (f *cellFacts) clone() cellFacts {
+	res := cellFacts{defaultZero: f.defaultZero, except: make(map[int]bool, len(f.except))}
+	for loc := range f.except {
+		res.except[loc] = true
+	}
+	return res
+}
+
+// loopFrame is the state saved when entering a loop body
+type loopFrame struct {
+	before  cellFacts
+	touched map[int]bool
+}
+
+// Optimizer is a streaming sink that rewrites ops before passing them on, so
+// it never needs the whole program. Adds are held back and folded per cell
+// until something could observe them or their statement ends. Every rewrite
+// preserves what the program does on the target.
+type Optimizer struct {
+	level  int
+	target *Target
+	next   Sink
+
+	pending   []int       // Cells with a held-back add, in the order first seen
+	adds      map[int]int // Held-back amount per cell
+	facts     cellFacts
+	loops     []loopFrame
+	skipDepth int // > 0 while dropping a loop that can never run
+	cleared   int // Cell cleared by the last op passed on, or -1
+}
+
+// NewOptimizer wraps next in an optimizer for the given level; at O_NONE ops
+// go straight to next
+func NewOptimizer(level int, target *Target, next Sink) Sink {
+	if level <= O_NONE {
+		return next
+	}
+	return &Optimizer{
+		level:   level,
+		target:  target,
+		next:    next,
+		adds:    make(map[int]int),
+		facts:   cellFacts{defaultZero: true, except: make(map[int]bool)},
+		cleared: -1,
+	}
+}
+
+func (o *Optimizer) touch(loc int) {
+	o.facts.set(loc, false)
+	if len(o.loops) > 0 {
+		o.loops[len(o.loops)-1].touched[loc] = true
+	}
+}
+
+func (o *Optimizer) knownZero(loc int) bool {
+	return o.level >= O_DATAFLOW && o.facts.isZero(loc)
+}
+
+func (o *Optimizer) Emit(op Op) {
+	if o.skipDepth > 0 {
+		switch op.Kind {
+		case OP_OPEN:
+			o.skipDepth++
+		case OP_CLOSE:
+			o.skipDepth--
+		}
+		return
+	}
+
+	switch op.Kind {
+	case OP_MARK:
+		// Held-back adds belong to the statement before the mark, so they
+		// only fold within a statement
+		o.flush()
+		o.next.Emit(op)
+		return
+
+	case OP_ADD:
+		if _, ok := o.adds[op.Loc]; !ok {
+			o.pending = append(o.pending, op.Loc)
+		}
+		o.adds[op.Loc] = o.target.Shortest(o.adds[op.Loc] + op.N)
+		return
+
+	case OP_CLEAR:
+		// Whatever was about to be added is overwritten
+		if _, ok := o.adds[op.Loc]; ok {
+			o.adds[op.Loc] = 0
+		}
+		o.flush()
+		if o.knownZero(op.Loc) || o.cleared == op.Loc {
+			return
+		}
+		o.touch(op.Loc)
+		o.facts.set(op.Loc, true)
+		o.next.Emit(op)
+		o.cleared = op.Loc
+		return
+
+	case OP_MOVE:
+		o.flush()
+		if o.knownZero(op.Loc) {
+			return
+		}
+		for _, t := range op.Targets {
+			o.touch(t.Loc)
+		}
+		o.touch(op.Loc)
+		o.facts.set(op.Loc, true)
+
+	case OP_OPEN:
+		o.flush()
+		if o.knownZero(op.Loc) {
+			o.skipDepth = 1
+			return
+		}
+		o.loops = append(o.loops, loopFrame{before: o.facts.clone(), touched: make(map[int]bool)})
+		o.facts = cellFacts{defaultZero: false, except: make(map[int]bool)}
+
+	case OP_CLOSE:
+		o.flush()
+		frame := o.loops[len(o.loops)-1]
+		o.loops = o.loops[:len(o.loops)-1]
+		o.facts = frame.before
+		for loc := range frame.touched {
+			o.touch(loc)
+		}
+		o.facts.set(op.Loc, true)
+
+	case OP_IN:
+		o.flush()
+		o.touch(op.Loc)
+
+	default:
+		o.flush()
+	}
+
+	o.next.Emit(op)
+	o.cleared = -1
+}
+
+// flush emits the held-back adds
+func (o *Optimizer) flush() {
+	for _, loc := range o.pending {
+		if n := o.adds[loc]; n != 0 {
+			o.touch(loc)
+			o.next.Emit(Add(loc, n))
+			o.cleared = -1
+		}
+		delete(o.adds, loc)
+	}
+	o.pending = o.pending[:0]
+}
+
+func (o *Optimizer) Close() error {
+	o.flush()
+	return o.next.Close()
+}
@@ -0,0 +1,117 @@
+package IR_test
+
+import (
+	"braining/IR"
+	"braining/Logging"
+	"braining/Tester"
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+var levels = []int{IR.O_NONE, IR.O_PEEPHOLE, IR.O_DATAFLOW}
+
+// TestCorpusAtEveryLevel runs the programs under testdata at every level
+// against their expected output
+func TestCorpusAtEveryLevel(t *testing.T) {
+	cases, err := Tester.Corpus([]string{"../testdata"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, level := range levels {
+		for _, r := range Tester.Run(cases, &Tester.Options{OptLevel: level}) {
+			if r.Err != nil {
+				t.Errorf("-O %d %s: %v", level, r.Case, r.Err)
+			} else if !r.Passed() {
+				t.Errorf("-O %d %s: wrong output\n%s", level, r.Case, Tester.Diff(r.Expected, r.Output))
+			}
+		}
+	}
+}
+
+// TestRandomProgramsAtEveryLevel checks that optimized random programs write
+// what the unoptimized ones do, on every target
+func TestRandomProgramsAtEveryLevel(t *testing.T) {
+	targets := []*IR.Target{
+		{CellBits: 8, Wrapping: true},
+		{CellBits: 8, Wrapping: false},
+		{CellBits: 8, Wrapping: true, AllowNegative: true},
+	}
+	n := 20
+	if testing.Short() {
+		n = 5
+	}
+
+	for _, target := range targets {
+		dir := t.TempDir()
+		for i := range n {
+			src, input := Tester.Generate(rand.New(rand.NewPCG(uint64(target.CellBits), uint64(i))), target)
+			base := filepath.Join(dir, fmt.Sprintf("p%d", i))
+			if err := os.WriteFile(base+".br", []byte(src), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(base+".in", input, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cases, err := Tester.Corpus([]string{dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opts := &Tester.Options{Target: target, OptLevel: IR.O_NONE}
+		want := Tester.Run(cases, opts)
+		for _, level := range levels[1:] {
+			opts.OptLevel = level
+			for i, r := range Tester.Run(cases, opts) {
+				// Past an overflow on cells that do not wrap a program means
+				// nothing, and optimization may fold the overflow away
+				var overflow *Logging.CellRangeInterpreterError
+				if errors.As(want[i].Err, &overflow) {
+					if !bytes.HasPrefix(r.Output, want[i].Output) {
+						t.Errorf("%+v -O %d %s: got %q, want it to begin with %q", *target, level, r.Case, r.Output, want[i].Output)
+					}
+					continue
+				}
+				if (r.Err == nil) != (want[i].Err == nil) || !bytes.Equal(r.Output, want[i].Output) {
+					src, _ := os.ReadFile(r.Program)
+					t.Errorf("%+v -O %d %s: got %q (%v), want %q (%v)\n%s",
+						*target, level, r.Case, r.Output, r.Err, want[i].Output, want[i].Err, src)
+				}
+			}
+		}
+	}
+}
+
+// TestAddsStayUnderTheirMark checks that adds are not folded into an earlier
+// statement, so source maps and costs credit them to the right one
+func TestAddsStayUnderTheirMark(t *testing.T) {
+	ops := []IR.Op{
+		IR.Mark(0), IR.Add(0, 1), IR.Add(0, 2),
+		IR.Mark(1), IR.Add(0, 3), IR.Add(1, 1),
+		IR.Mark(2), IR.Out(1),
+	}
+	want := []IR.Op{
+		IR.Mark(0), IR.Add(0, 3),
+		IR.Mark(1), IR.Add(0, 3), IR.Add(1, 1),
+		IR.Mark(2), IR.Out(1),
+	}
+	for _, level := range levels[1:] {
+		p := &IR.Program{}
+		o := IR.NewOptimizer(level, IR.DefaultTarget(), p)
+		for _, op := range ops {
+			o.Emit(op)
+		}
+		if err := o.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.EqualFunc(p.Ops, want, func(a, b IR.Op) bool { return fmt.Sprint(a) == fmt.Sprint(b) }) {
+			t.Errorf("-O %d: got %v, want %v", level, p.Ops, want)
+		}
+	}
+}
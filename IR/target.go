@@ -0,0 +1,66 @@
+package IR
+
+import "fmt"
+
+// Target describes the cells of the interpreter the generated code will run on
+type Target struct {
+	CellBits      int  // Width of a cell: 8, 16 or 32
+	Wrapping      bool // Cells wrap around on overflow and underflow
+	AllowNegative bool // Cells are signed and may hold values below zero
+}
+
+// DefaultTarget is the classic Brainf*** machine with unsigned 8-bit wrapping cells
+func DefaultTarget() *Target {
+	return &Target{CellBits: 8, Wrapping: true, AllowNegative: false}
+}
+
+func (t *Target) Valid() bool {
+	return t.Unsupported() == ""
+}
+
+// Unsupported explains why no code can be generated for the target, or is
+// empty when it can be. Clearing and moving cells counts them down to zero,
+// which never ends for a negative value on cells that do not wrap.
+func (t *Target) Unsupported() string {
+	switch {
+	case t.CellBits != 8 && t.CellBits != 16 && t.CellBits != 32:
+		return fmt.Sprintf("cell width of %d bits", t.CellBits)
+	case t.AllowNegative && !t.Wrapping:
+		return "signed cells that do not wrap"
+	}
+	return ""
+}
+
+// Modulus is the number of distinct values a cell can hold
+func (t *Target) Modulus() int {
+	return 1 << t.CellBits
+}
+
+// MaxLiteral is the largest literal that can be stored in a cell
+func (t *Target) MaxLiteral() int {
+	if t.AllowNegative {
+		return t.Modulus()/2 - 1
+	}
+	return t.Modulus() - 1
+}
+
+// Saturating reports whether subtraction must stop at zero instead of going
+// below it, which is the case for unsigned cells that do not wrap
+func (t *Target) Saturating() bool {
+	return !t.Wrapping && !t.AllowNegative
+}
+
+// Shortest maps a change to a cell onto the equivalent change with the fewest
+// steps; on wrapping targets that may mean going the other way around
+func (t *Target) Shortest(delta int) int {
+	if !t.Wrapping {
+		return delta
+	}
+	delta %= t.Modulus()
+	if delta > t.Modulus()/2 {
+		delta -= t.Modulus()
+	} else if delta < -t.Modulus()/2 {
+		delta += t.Modulus()
+	}
+	return delta
+}
@@ -0,0 +1,189 @@
+package Interpreter
+
+import (
+	"braining/Logging"
+	"bufio"
+	"io"
+)
+
+// EOFMode is what a read does to the cell once input has run out
+type EOFMode int
+
+const (
+	EOF_UNCHANGED EOFMode = iota // Leave the cell as it was
+	EOF_ZERO                     // Set the cell to 0
+	EOF_MAX                      // Set the cell to its maximum, i.e. -1
+)
+
+type Config struct {
+	CellBits int  // 8, 16 or 32
+	Wrapping bool // Cells wrap around; when false, taking a cell out of its range fails
+	Signed   bool // Cells hold two's complement values, from -2^(CellBits-1) up
+	TapeSize int
+	EOF      EOFMode
+	MaxSteps int // Instructions a run may execute before failing; 0 means no limit
+}
+
+func DefaultConfig() Config {
+	return Config{CellBits: 8, Wrapping: true, TapeSize: 30000, EOF: EOF_UNCHANGED}
+}
+
+// Machine runs a program. Cells are stored as CellBits wide unsigned values
+// whatever their signedness; Value says what a cell holds.
+type Machine struct {
+	Config
+	Program *Program
+	Tape    []uint32
+	Pointer int
+	PC      int // Index of the next instruction in Program.Code
+	Steps   int // Instructions executed so far
+
+	// OnBreakpoint is called when a ! is reached, after output is flushed
+	OnBreakpoint func(m *Machine)
+
+	in   *bufio.Reader
+	out  *bufio.Writer
+	mask uint32
+}
+
+// NewMachine prepares a program to run on a fresh tape. Invalid cell widths and
+// tape sizes fall back to the defaults.
+func NewMachine(program *Program, config Config, in io.Reader, out io.Writer) *Machine {
+	def := DefaultConfig()
+	if config.CellBits != 8 && config.CellBits != 16 && config.CellBits != 32 {
+		config.CellBits = def.CellBits
+	}
+	if config.TapeSize <= 0 {
+		config.TapeSize = def.TapeSize
+	}
+	return &Machine{
+		Config:  config,
+		Program: program,
+		Tape:    make([]uint32, config.TapeSize),
+		in:      bufio.NewReader(in),
+		out:     bufio.NewWriter(out),
+		mask:    uint32(uint64(1)<<config.CellBits - 1),
+	}
+}
+
+// Done reports whether the program has run to the end
+func (m *Machine) Done() bool {
+	return m.PC >= len(m.Program.Code)
+}
+
+// Current returns the next instruction to run; it must not be called when Done
+func (m *Machine) Current() Instr {
+	return m.Program.Code[m.PC]
+}
+
+// Run executes until the program ends or fails, then flushes output
+func (m *Machine) Run() error {
+	for !m.Done() {
+		if err := m.Step(); err != nil {
+			m.out.Flush()
+			return err
+		}
+	}
+	return m.out.Flush()
+}
+
+// Step executes a single instruction
+func (m *Machine) Step() error {
+	if m.MaxSteps > 0 && m.Steps >= m.MaxSteps {
+		return &Logging.StepLimitInterpreterError{Limit: m.MaxSteps}
+	}
+	instr := m.Program.Code[m.PC]
+	m.Steps++
+	m.PC++
+
+	switch instr.Op {
+	case I_ADD:
+		// A folded run of + and - is checked as the single change it makes
+		if v := m.Value(m.Pointer) + instr.Arg; !m.Wrapping && (v < m.min() || v > m.max()) {
+			return &Logging.CellRangeInterpreterError{Value: v, Pointer: m.Pointer, Offset: instr.Offset}
+		}
+		m.Tape[m.Pointer] = (m.Tape[m.Pointer] + uint32(instr.Arg)) & m.mask
+	case I_MOVE:
+		m.Pointer += instr.Arg
+		if m.Pointer < 0 || m.Pointer >= len(m.Tape) {
+			return &Logging.TapeBoundsInterpreterError{Pointer: m.Pointer, Offset: instr.Offset}
+		}
+	case I_CLEAR:
+		m.Tape[m.Pointer] = 0
+	case I_OPEN:
+		if m.Tape[m.Pointer] == 0 {
+			m.PC = instr.Arg + 1
+		}
+	case I_CLOSE:
+		if m.Tape[m.Pointer] != 0 {
+			m.PC = instr.Arg + 1
+		}
+	case I_IN:
+		// Flush first so prompts are visible before blocking on input
+		if m.out.Buffered() > 0 {
+			if err := m.out.Flush(); err != nil {
+				return err
+			}
+		}
+		b, err := m.in.ReadByte()
+		if err == io.EOF {
+			switch m.EOF {
+			case EOF_ZERO:
+				m.Tape[m.Pointer] = 0
+			case EOF_MAX:
+				m.Tape[m.Pointer] = m.mask
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		m.Tape[m.Pointer] = uint32(b)
+	case I_OUT:
+		return m.out.WriteByte(byte(m.Tape[m.Pointer]))
+	case I_BREAKPOINT:
+		if m.OnBreakpoint != nil {
+			if err := m.out.Flush(); err != nil {
+				return err
+			}
+			m.OnBreakpoint(m)
+		}
+	}
+	return nil
+}
+
+// Value returns what a cell holds, negative for the upper half of the range
+// when cells are signed
+func (m *Machine) Value(cell int) int {
+	v := int(m.Tape[cell])
+	if m.Signed && v > m.max() {
+		v -= int(m.mask) + 1
+	}
+	return v
+}
+
+func (m *Machine) min() int {
+	if m.Signed {
+		return -int(m.mask/2) - 1
+	}
+	return 0
+}
+
+func (m *Machine) max() int {
+	if m.Signed {
+		return int(m.mask / 2)
+	}
+	return int(m.mask)
+}
+
+// Load replaces the program and starts it from the beginning, keeping the
+// tape and pointer as the last program left them
+func (m *Machine) Load(program *Program) {
+	m.Program = program
+	m.PC = 0
+}
+
+// Flush writes out any buffered output
+func (m *Machine) Flush() error {
+	return m.out.Flush()
+}
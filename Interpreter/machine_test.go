@@ -0,0 +1,195 @@
+package Interpreter_test
+
+import (
+	"braining/Interpreter"
+	"braining/Logging"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func run(t *testing.T, src string, config Interpreter.Config, input string) (*Interpreter.Machine, []byte, error) {
+	t.Helper()
+	p, err := Interpreter.Compile(src)
+	if err != nil {
+		t.Fatalf("%q: %v", src, err)
+	}
+	out := bytes.Buffer{}
+	m := Interpreter.NewMachine(p, config, strings.NewReader(input), &out)
+	err = m.Run()
+	return m, out.Bytes(), err
+}
+
+func TestCellWidths(t *testing.T) {
+	tests := []struct {
+		bits int
+		src  string
+		want uint32
+	}{
+		{8, "-", 0xff},
+		{8, "-+", 0},
+		{16, "-", 0xffff},
+		{32, "-", 0xffffffff},
+		{8, strings.Repeat("+", 257), 1},
+		{16, strings.Repeat("+", 257), 257},
+		{0, "-", 0xff}, // Invalid widths fall back to 8 bits
+	}
+	for _, tt := range tests {
+		config := Interpreter.DefaultConfig()
+		config.CellBits = tt.bits
+		m, _, err := run(t, tt.src, config, "")
+		if err != nil {
+			t.Errorf("%d bits %q: %v", tt.bits, tt.src, err)
+		} else if m.Tape[0] != tt.want {
+			t.Errorf("%d bits %q: cell %#x, want %#x", tt.bits, tt.src, m.Tape[0], tt.want)
+		}
+	}
+}
+
+func TestEOFModes(t *testing.T) {
+	tests := []struct {
+		mode Interpreter.EOFMode
+		bits int
+		want string
+	}{
+		{Interpreter.EOF_UNCHANGED, 8, "abg"},
+		{Interpreter.EOF_ZERO, 8, "ab\x00"},
+		{Interpreter.EOF_MAX, 8, "ab\xff"},
+		{Interpreter.EOF_MAX, 16, "ab\xff"},
+	}
+	for _, tt := range tests {
+		config := Interpreter.DefaultConfig()
+		config.EOF = tt.mode
+		config.CellBits = tt.bits
+		_, out, err := run(t, ",.,.+++++,.", config, "ab")
+		if err != nil {
+			t.Errorf("mode %d: %v", tt.mode, err)
+		} else if string(out) != tt.want {
+			t.Errorf("mode %d: got %q, want %q", tt.mode, out, tt.want)
+		}
+	}
+}
+
+func TestStepLimit(t *testing.T) {
+	config := Interpreter.DefaultConfig()
+	config.MaxSteps = 100
+	m, _, err := run(t, "+[]", config, "")
+	var limit *Logging.StepLimitInterpreterError
+	if !errors.As(err, &limit) {
+		t.Fatalf("got %v, want the step limit", err)
+	}
+	if m.Steps != 100 {
+		t.Errorf("stopped after %d steps, want 100", m.Steps)
+	}
+
+	if _, _, err := run(t, "+++[-]", config, ""); err != nil {
+		t.Errorf("a short program hit the limit: %v", err)
+	}
+}
+
+func TestTapeBounds(t *testing.T) {
+	config := Interpreter.DefaultConfig()
+	config.TapeSize = 4
+	for _, src := range []string{"<", ">>>>", "+[>+]"} {
+		var bounds *Logging.TapeBoundsInterpreterError
+		if _, _, err := run(t, src, config, ""); !errors.As(err, &bounds) {
+			t.Errorf("%q: got %v, want a tape bounds error", src, err)
+		}
+	}
+}
+
+// countingWriter counts the writes that reach it
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+// TestReadFlushesPendingOutput checks that output is flushed before a read
+// only when some is waiting, so a prompt shows but reads cost nothing extra
+func TestReadFlushesPendingOutput(t *testing.T) {
+	tests := []struct {
+		src    string
+		writes int
+	}{
+		{",,,,", 0},
+		{"+.,,,,", 1},
+		{"+.,.,,", 2},
+	}
+	for _, tt := range tests {
+		p, err := Interpreter.Compile(tt.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := &countingWriter{}
+		m := Interpreter.NewMachine(p, Interpreter.DefaultConfig(), strings.NewReader("abcd"), out)
+		if err := m.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if out.writes != tt.writes {
+			t.Errorf("%q: %d writes, want %d", tt.src, out.writes, tt.writes)
+		}
+	}
+}
+
+func TestBreakpoint(t *testing.T) {
+	p, err := Interpreter.Compile("+.!+")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := bytes.Buffer{}
+	m := Interpreter.NewMachine(p, Interpreter.DefaultConfig(), strings.NewReader(""), &out)
+	hits := 0
+	m.OnBreakpoint = func(m *Interpreter.Machine) {
+		hits++
+		if out.Len() != 1 || m.Tape[0] != 1 {
+			t.Errorf("at the breakpoint: output %q, cell %d", out.Bytes(), m.Tape[0])
+		}
+	}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if hits != 1 || m.Tape[0] != 2 {
+		t.Errorf("%d hits, cell %d", hits, m.Tape[0])
+	}
+}
+
+func TestCellRange(t *testing.T) {
+	tests := []struct {
+		bits     int
+		wrapping bool
+		signed   bool
+		src      string
+		value    int // What cell 0 holds, unless the run fails
+		fails    bool
+	}{
+		{8, true, false, "-", 255, false},
+		{8, true, true, "-", -1, false},
+		{8, true, true, strings.Repeat("+", 128), -128, false},
+		{16, true, true, "--", -2, false},
+		{8, false, false, "-", 0, true},
+		{8, false, false, "+-+-", 0, false},
+		{8, false, false, strings.Repeat("+", 255), 255, false},
+		{8, false, false, strings.Repeat("+", 256), 0, true},
+		{16, false, false, strings.Repeat("+", 256), 256, false},
+		{8, false, false, "+[-]-", 0, true},
+	}
+	for _, tt := range tests {
+		config := Interpreter.DefaultConfig()
+		config.CellBits = tt.bits
+		config.Wrapping = tt.wrapping
+		config.Signed = tt.signed
+		m, _, err := run(t, tt.src, config, "")
+		var cellRange *Logging.CellRangeInterpreterError
+		if tt.fails != errors.As(err, &cellRange) {
+			t.Errorf("%d bits %q: got %v", tt.bits, tt.src, err)
+		} else if !tt.fails && m.Value(0) != tt.value {
+			t.Errorf("%d bits %q: cell holds %d, want %d", tt.bits, tt.src, m.Value(0), tt.value)
+		}
+	}
+}
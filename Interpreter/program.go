@@ -0,0 +1,125 @@
+package Interpreter
+
+import (
+	"braining/Logging"
+)
+
+type OpCode byte
+
+const (
+	I_ADD        OpCode = iota // Cell += Arg
+	I_MOVE                     // Pointer += Arg
+	I_CLEAR                    // Cell = 0, from [-] or [+]
+	I_OPEN                     // Jump past the matching close (Arg) if the cell is zero
+	I_CLOSE                    // Jump back past the matching open (Arg) if the cell is not zero
+	I_IN                       // Read a byte into the cell
+	I_OUT                      // Write the cell as a byte
+	I_BREAKPOINT               // Call the breakpoint hook
+)
+
+// Instr is one compiled instruction. Offset is the position in the source of
+// the first character it was compiled from.
+type Instr struct {
+	Op     OpCode
+	Arg    int
+	Offset int
+}
+
+// Program is Brainf*** compiled for the interpreter: runs of + - < > are folded,
+// clear loops become a single instruction and every bracket knows its partner
+type Program struct {
+	Code []Instr
+}
+
+func isCommand(c byte) bool {
+	switch c {
+	case '+', '-', '<', '>', '.', ',', '[', ']', '!':
+		return true
+	}
+	return false
+}
+
+// Compile turns Brainf*** source into a program; characters that are not
+// commands are ignored
+func Compile(src string) (*Program, error) {
+	p := &Program{}
+	opens := []int{}
+
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch c {
+		case '+', '-':
+			delta := 1
+			if c == '-' {
+				delta = -1
+			}
+			p.fold(I_ADD, delta, i)
+		case '>', '<':
+			delta := 1
+			if c == '<' {
+				delta = -1
+			}
+			p.fold(I_MOVE, delta, i)
+		case '[':
+			if j, ok := clearLoop(src, i); ok {
+				p.Code = append(p.Code, Instr{Op: I_CLEAR, Offset: i})
+				i = j
+				continue
+			}
+			opens = append(opens, len(p.Code))
+			p.Code = append(p.Code, Instr{Op: I_OPEN, Offset: i})
+		case ']':
+			if len(opens) == 0 {
+				return nil, &Logging.UnmatchedBracketInterpreterError{Offset: i}
+			}
+			open := opens[len(opens)-1]
+			opens = opens[:len(opens)-1]
+			p.Code[open].Arg = len(p.Code)
+			p.Code = append(p.Code, Instr{Op: I_CLOSE, Arg: open, Offset: i})
+		case ',':
+			p.Code = append(p.Code, Instr{Op: I_IN, Offset: i})
+		case '.':
+			p.Code = append(p.Code, Instr{Op: I_OUT, Offset: i})
+		case '!':
+			p.Code = append(p.Code, Instr{Op: I_BREAKPOINT, Offset: i})
+		}
+	}
+
+	if len(opens) != 0 {
+		return nil, &Logging.UnmatchedBracketInterpreterError{Offset: p.Code[opens[len(opens)-1]].Offset}
+	}
+	return p, nil
+}
+
+// fold extends the previous instruction if it is the same kind, dropping it
+// if the run cancels out
+func (p *Program) fold(op OpCode, delta, offset int) {
+	if n := len(p.Code); n > 0 && p.Code[n-1].Op == op {
+		p.Code[n-1].Arg += delta
+		if p.Code[n-1].Arg == 0 {
+			p.Code = p.Code[:n-1]
+		}
+		return
+	}
+	p.Code = append(p.Code, Instr{Op: op, Arg: delta, Offset: offset})
+}
+
+// clearLoop reports whether a [-] or [+] starts at i, ignoring non-commands
+// inside it, and returns the index of its closing bracket
+func clearLoop(src string, i int) (int, bool) {
+	body := []byte{}
+	for j := i + 1; j < len(src); j++ {
+		if !isCommand(src[j]) {
+			continue
+		}
+		if src[j] != ']' {
+			body = append(body, src[j])
+			if len(body) > 1 {
+				return 0, false
+			}
+			continue
+		}
+		return j, len(body) == 1 && (body[0] == '-' || body[0] == '+')
+	}
+	return 0, false
+}
@@ -0,0 +1,74 @@
+package Interpreter_test
+
+import (
+	"braining/Interpreter"
+	"braining/Logging"
+	"errors"
+	"testing"
+)
+
+func TestCompileUnmatched(t *testing.T) {
+	tests := []struct {
+		src    string
+		offset int
+	}{
+		{"+]", 1},
+		{"[-]]", 3},
+		{"+[", 1},
+		{"[[]", 0},
+		{"[>[<]", 0},
+		{"[[>]", 0},
+		{"[][", 2},
+	}
+	for _, tt := range tests {
+		_, err := Interpreter.Compile(tt.src)
+		var unmatched *Logging.UnmatchedBracketInterpreterError
+		if !errors.As(err, &unmatched) {
+			t.Errorf("%q: got %v, want an unmatched bracket", tt.src, err)
+		} else if unmatched.Offset != tt.offset {
+			t.Errorf("%q: at %d, want %d", tt.src, unmatched.Offset, tt.offset)
+		}
+	}
+}
+
+func TestCompileFolds(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []Interpreter.Instr
+	}{
+		{"+++--", []Interpreter.Instr{{Op: Interpreter.I_ADD, Arg: 1}}},
+		{"+-", nil},
+		{">>x<", []Interpreter.Instr{{Op: Interpreter.I_MOVE, Arg: 1}}},
+		{"+[ - ]", []Interpreter.Instr{{Op: Interpreter.I_ADD, Arg: 1}, {Op: Interpreter.I_CLEAR, Offset: 1}}},
+		{"[->+<]", []Interpreter.Instr{
+			{Op: Interpreter.I_OPEN, Arg: 5},
+			{Op: Interpreter.I_ADD, Arg: -1, Offset: 1},
+			{Op: Interpreter.I_MOVE, Arg: 1, Offset: 2},
+			{Op: Interpreter.I_ADD, Arg: 1, Offset: 3},
+			{Op: Interpreter.I_MOVE, Arg: -1, Offset: 4},
+			{Op: Interpreter.I_CLOSE, Arg: 0, Offset: 5},
+		}},
+		{",.!", []Interpreter.Instr{
+			{Op: Interpreter.I_IN},
+			{Op: Interpreter.I_OUT, Offset: 1},
+			{Op: Interpreter.I_BREAKPOINT, Offset: 2},
+		}},
+	}
+	for _, tt := range tests {
+		p, err := Interpreter.Compile(tt.src)
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		if len(p.Code) != len(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.src, p.Code, tt.want)
+			continue
+		}
+		for i := range p.Code {
+			if p.Code[i] != tt.want[i] {
+				t.Errorf("%q: got %v, want %v", tt.src, p.Code, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package LSP
+
+import (
+	"braining/AST"
+	"braining/Compiler"
+	"braining/Logging"
+	"braining/Parser"
+	"braining/Query"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+const MAX_DIAGNOSTICS = 20 // Errors published per document
+
+// document is an open file and what was learned from its current text
+type document struct {
+	uri         string
+	version     int
+	text        string
+	lines       []string
+	defines     []string // Names whose #if regions are compiled
+	diagnostics []Diagnostic
+
+	// The program with every #if region included, so that queries see all
+	// of the source. When the branches only parse apart it is the program as
+	// compiled, and nil when that does not parse either.
+	syntax  *AST.Ast
+	index   *Query.Index
+	partial bool // syntax leaves out the inactive #if branches
+
+	costs *Compiler.CostReport // nil when the program does not compile
+}
+
+// analyze parses and compiles the document. After an error the lines it
+// covers are blanked out and the rest is tried again, so that later errors
+// are published as well; some of those may only follow from the first.
+// Compile errors are only looked for when the program parses.
+func (d *document) analyze() {
+	d.lines = strings.Split(d.text, "\n")
+	d.diagnostics = []Diagnostic{}
+	d.syntax, d.index, d.costs = nil, nil, nil
+	d.partial = false
+	logger := Logging.NewRecoverableLogger("braining_lsp")
+
+	var syntax AST.Ast
+	if Logging.Catch(func() {
+		p := Parser.NewParser(d.text, logger)
+		p.KeepTrivia()
+		syntax = p.Parse()
+	}) == nil {
+		d.syntax = &syntax
+		d.index = Query.NewIndex(&syntax)
+	}
+
+	parse := func(text string) (a AST.Ast, err error) {
+		err = Logging.Catch(func() {
+			p := Parser.NewParser(text, logger)
+			for _, name := range d.defines {
+				p.Define(name)
+			}
+			a = p.Parse()
+		})
+		return a, err
+	}
+	text := d.text
+	a, err := parse(text)
+	if err != nil {
+		for d.report(err) {
+			var ok bool
+			if text, ok = blank(text, err); !ok {
+				break
+			}
+			if _, err = parse(text); err == nil {
+				break
+			}
+		}
+		return
+	}
+	if d.syntax == nil {
+		d.syntax = &a
+		d.index = Query.NewIndex(&a)
+		d.partial = true
+	}
+
+	for {
+		var c *Compiler.Compiler
+		if caught := Logging.Catch(func() {
+			c = Compiler.NewCompiler(a, nil, logger)
+			c.Source = text
+			err = c.CompileTo(io.Discard)
+		}); caught != nil {
+			err = caught
+		}
+		if err == nil {
+			if len(d.diagnostics) == 0 {
+				d.costs = c.Costs
+			}
+			return
+		}
+		if !d.report(err) {
+			return
+		}
+		var ok bool
+		if text, ok = blank(text, err); !ok {
+			return
+		}
+		if a, err = parse(text); err != nil {
+			return // Blanking broke up a block, so stop here
+		}
+	}
+}
+
+// report records a diagnostic for err, and reports whether there is room for
+// more
+func (d *document) report(err error) bool {
+	d.diagnostics = append(d.diagnostics, d.diagnostic(err))
+	return len(d.diagnostics) < MAX_DIAGNOSTICS
+}
+
+// blank replaces the lines covered by err with empty ones, keeping the lines
+// after them where they were. It fails if err has no position or there is
+// nothing left to blank there.
+func blank(text string, err error) (string, bool) {
+	var srcErr *Logging.SourceError
+	if !errors.As(err, &srcErr) || srcErr.Line < 1 {
+		return text, false
+	}
+	lines := strings.Split(text, "\n")
+	blanked := false
+	for i := srcErr.Line - 1; i < min(max(srcErr.EndLine, srcErr.Line), len(lines)); i++ {
+		if strings.TrimSpace(lines[i]) != "" {
+			lines[i] = ""
+			blanked = true
+		}
+	}
+	return strings.Join(lines, "\n"), blanked
+}
+
+// hover describes the cost of the statement or macro call at pos
+func (d *document) hover(pos Position) *Hover {
+	if d.costs == nil {
+		return nil
+	}
+	at := d.sourcePos(pos)
+	e, ok := d.costs.Lookup(at.Line, at.Col)
+	if !ok {
+		return nil
+	}
+	what := "Statement"
+	if e.Macro != "" {
+		what = "Expansion of `" + e.Macro + "`"
+	}
+	return &Hover{
+		Contents: MarkupContent{
+			Kind: MARKUP_MARKDOWN,
+			Value: fmt.Sprintf("%s: %d instructions, %d pointer moves, %d temps",
+				what, e.Instructions, e.Moves, e.Temps),
+		},
+		Range: Range{
+			Start: d.position(AST.Pos{Line: e.Line, Col: e.Col}),
+			End:   d.position(AST.Pos{Line: e.EndLine, Col: e.EndCol}),
+		},
+	}
+}
+
+func (d *document) diagnostic(err error) Diagnostic {
+	diag := Diagnostic{Severity: SEVERITY_ERROR, Source: "braining", Message: err.Error()}
+	var srcErr *Logging.SourceError
+	if errors.As(err, &srcErr) {
+		diag.Range = Range{
+			Start: d.position(AST.Pos{Line: srcErr.Line, Col: srcErr.Col}),
+			End:   d.position(AST.Pos{Line: srcErr.EndLine, Col: srcErr.EndCol}),
+		}
+	}
+	return diag
+}
+
+// position converts a 1-based source position, whose column counts runes, to
+// a protocol position, whose character counts UTF-16 code units
+func (d *document) position(pos AST.Pos) Position {
+	res := Position{Line: max(pos.Line-1, 0)}
+	col := max(pos.Col-1, 0)
+	if res.Line < len(d.lines) {
+		for _, r := range d.lines[res.Line] {
+			if col == 0 {
+				break
+			}
+			res.Character += utf16.RuneLen(r)
+			col--
+		}
+	}
+	res.Character += col // Past the end of the line
+	return res
+}
+
+// sourcePos converts a protocol position to a 1-based source position. A
+// character inside a surrogate pair counts as the start of its rune.
+func (d *document) sourcePos(pos Position) AST.Pos {
+	res := AST.Pos{Line: pos.Line + 1, Col: 1}
+	units := pos.Character
+	if pos.Line >= 0 && pos.Line < len(d.lines) {
+		for _, r := range d.lines[pos.Line] {
+			if units < utf16.RuneLen(r) {
+				return res
+			}
+			units -= utf16.RuneLen(r)
+			res.Col++
+		}
+	}
+	res.Col += max(units, 0)
+	return res
+}
+
+func (d *document) occurrenceRange(o Query.Occurrence) Range {
+	return Range{Start: d.position(o.Pos), End: d.position(o.End())}
+}
+
+func (d *document) identRange(id AST.IdentToken) Range {
+	return Range{
+		Start: d.position(id.Pos),
+		End:   d.position(AST.Pos{Line: id.Pos.Line, Col: id.Pos.Col + len([]rune(id.Name))}),
+	}
+}
+
+func (d *document) spanRange(span AST.Span) Range {
+	return Range{Start: d.position(span.Start), End: d.position(span.End)}
+}
+
+// symbols lists the macros of the document, with their parameters, and the
+// variables defined outside of macros at the statement that first sets them
+func (d *document) symbols() []DocumentSymbol {
+	res := []DocumentSymbol{}
+	if d.syntax == nil {
+		return res
+	}
+	seen := make(map[string]bool)
+	variable := func(id AST.IdentToken, span AST.Span) {
+		if seen[id.Name] {
+			return
+		}
+		seen[id.Name] = true
+		res = append(res, DocumentSymbol{
+			Name:           id.Name,
+			Kind:           SYMBOL_KIND_VARIABLE,
+			Range:          d.spanRange(span),
+			SelectionRange: d.identRange(id),
+		})
+	}
+
+	var walk func(b *AST.BlockNode)
+	walk = func(b *AST.BlockNode) {
+		for _, n := range b.Nodes {
+			switch n.Type() {
+			case AST.N_ASSIGN:
+				variable(n.(*AST.AssignNode).Left, n.Position())
+			case AST.N_READ:
+				variable(n.(*AST.ReadNode).Value, n.Position())
+			case AST.N_IF:
+				walk(&n.(*AST.IfNode).Block)
+			case AST.N_IFNOT:
+				walk(&n.(*AST.IfNotNode).Block)
+			case AST.N_WHILE:
+				walk(&n.(*AST.WhileNode).Block)
+			case AST.N_WHILENOT:
+				walk(&n.(*AST.WhileNotNode).Block)
+			case AST.N_MACRO:
+				res = append(res, d.macroSymbol(n.(*AST.MacroNode)))
+			}
+			// Macro expansions are skipped: their statements belong to the macro
+		}
+	}
+	walk(&d.syntax.Root)
+	return res
+}
+
+func (d *document) macroSymbol(m *AST.MacroNode) DocumentSymbol {
+	params := make([]string, len(m.Params))
+	children := make([]DocumentSymbol, len(m.Params))
+	for i, p := range m.Params {
+		params[i] = p.Name
+		children[i] = DocumentSymbol{
+			Name:           p.Name,
+			Kind:           SYMBOL_KIND_VARIABLE,
+			Range:          d.identRange(p),
+			SelectionRange: d.identRange(p),
+		}
+	}
+	end := m.Block.End
+	end.Col += len("emcro")
+	return DocumentSymbol{
+		Name:           m.Name.Name,
+		Detail:         strings.Join(params, " "),
+		Kind:           SYMBOL_KIND_FUNCTION,
+		Range:          Range{Start: d.position(m.Span.Start), End: d.position(end)},
+		SelectionRange: d.identRange(m.Name),
+		Children:       children,
+	}
+}
@@ -0,0 +1,323 @@
+package LSP
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Server speaks the Language Server Protocol over a pair of streams. Every
+// open document is reparsed and recompiled on each change, and its
+// diagnostics are published straight away. Hovers show what each statement
+// costs in the generated Brainf***.
+type Server struct {
+	in          *bufio.Reader
+	out         io.Writer
+	docs        map[string]*document
+	defines     []string
+	handlers    map[string]func(params json.RawMessage) (any, error)
+	initialized bool
+	shutdown    bool
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	s := &Server{
+		in:   bufio.NewReader(in),
+		out:  out,
+		docs: make(map[string]*document),
+	}
+	s.handlers = map[string]func(json.RawMessage) (any, error){
+		"initialize":                  s.initialize,
+		"initialized":                 s.ignore,
+		"shutdown":                    s.shutdownRequest,
+		"textDocument/didOpen":        s.didOpen,
+		"textDocument/didChange":      s.didChange,
+		"textDocument/didClose":       s.didClose,
+		"textDocument/documentSymbol": s.documentSymbol,
+		"textDocument/definition":     s.definition,
+		"textDocument/references":     s.references,
+		"textDocument/prepareRename":  s.prepareRename,
+		"textDocument/rename":         s.rename,
+		"textDocument/hover":          s.hover,
+	}
+	return s
+}
+
+// Define compiles the regions guarded by #if name in documents opened
+// afterwards
+func (s *Server) Define(name string) {
+	s.defines = append(s.defines, name)
+}
+
+// Run serves messages until the client sends exit or closes the stream. It
+// fails if the client exits without asking the server to shut down first.
+func (s *Server) Run() error {
+	for {
+		body, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			if err := s.fail(nil, &ResponseError{Code: E_PARSE_ERROR, Message: err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exit before shutdown")
+			}
+			return nil
+		}
+		if err := s.handle(req); err != nil {
+			return err
+		}
+	}
+}
+
+// handle runs the handler for a message and answers it if it is a request;
+// only errors writing to the client are returned
+func (s *Server) handle(req request) error {
+	handler, ok := s.handlers[req.Method]
+	switch {
+	case !ok && req.ID == nil:
+		return nil // Notifications that are not understood are dropped
+	case !ok:
+		return s.fail(req.ID, &ResponseError{Code: E_METHOD_NOT_FOUND, Message: "unknown method " + req.Method})
+	case !s.initialized && req.Method != "initialize":
+		if req.ID == nil {
+			return nil
+		}
+		return s.fail(req.ID, &ResponseError{Code: E_NOT_INITIALIZED, Message: "server not initialized"})
+	}
+
+	result, err := call(handler, req.Params)
+	if req.ID == nil {
+		return nil
+	}
+	if err != nil {
+		var respErr *ResponseError
+		if !errors.As(err, &respErr) {
+			respErr = &ResponseError{Code: E_INVALID_PARAMS, Message: err.Error()}
+		}
+		return s.fail(req.ID, respErr)
+	}
+	return writeMessage(s.out, response{JSONRPC: "2.0", ID: req.ID, Result: result})
+}
+
+// call runs a handler, turning a panic into a failed request so that a bug
+// in one handler does not end the session
+func call(handler func(json.RawMessage) (any, error), params json.RawMessage) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &ResponseError{Code: E_REQUEST_FAILED, Message: fmt.Sprintf("internal error: %v", r)}
+		}
+	}()
+	return handler(params)
+}
+
+func (s *Server) fail(id *json.RawMessage, err *ResponseError) error {
+	return writeMessage(s.out, errorResponse{JSONRPC: "2.0", ID: id, Error: err})
+}
+
+func (s *Server) notify(method string, params any) error {
+	return writeMessage(s.out, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (s *Server) publish(d *document) error {
+	return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+		URI:         d.uri,
+		Version:     d.version,
+		Diagnostics: d.diagnostics,
+	})
+}
+
+// ----------------------------------------------------
+// Handlers
+// ----------------------------------------------------
+
+func (s *Server) ignore(json.RawMessage) (any, error) {
+	return nil, nil
+}
+
+func (s *Server) initialize(json.RawMessage) (any, error) {
+	s.initialized = true
+	return map[string]any{
+		"capabilities": map[string]any{
+			"positionEncoding":       POSITION_ENCODING_UTF16,
+			"textDocumentSync":       SYNC_FULL,
+			"documentSymbolProvider": true,
+			"definitionProvider":     true,
+			"referencesProvider":     true,
+			"renameProvider":         map[string]any{"prepareProvider": true},
+			"hoverProvider":          true,
+		},
+		"serverInfo": map[string]any{"name": "braining"},
+	}, nil
+}
+
+func (s *Server) shutdownRequest(json.RawMessage) (any, error) {
+	s.shutdown = true
+	return nil, nil
+}
+
+func (s *Server) didOpen(params json.RawMessage) (any, error) {
+	var p didOpenParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	d := &document{uri: p.TextDocument.URI, version: p.TextDocument.Version, text: p.TextDocument.Text, defines: s.defines}
+	s.docs[d.uri] = d
+	d.analyze()
+	return nil, s.publish(d)
+}
+
+func (s *Server) didChange(params json.RawMessage) (any, error) {
+	var p didChangeParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	d, ok := s.docs[p.TextDocument.URI]
+	if !ok || len(p.ContentChanges) == 0 {
+		return nil, nil
+	}
+	// With full sync the last change holds the whole text
+	d.text = p.ContentChanges[len(p.ContentChanges)-1].Text
+	d.version = p.TextDocument.Version
+	d.analyze()
+	return nil, s.publish(d)
+}
+
+func (s *Server) didClose(params json.RawMessage) (any, error) {
+	var p didCloseParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	delete(s.docs, p.TextDocument.URI)
+	return nil, s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+		URI:         p.TextDocument.URI,
+		Diagnostics: []Diagnostic{},
+	})
+}
+
+// document returns an open document or fails the request
+func (s *Server) document(uri string) (*document, error) {
+	d, ok := s.docs[uri]
+	if !ok {
+		return nil, &ResponseError{Code: E_INVALID_PARAMS, Message: "document not open: " + uri}
+	}
+	return d, nil
+}
+
+func (s *Server) documentSymbol(params json.RawMessage) (any, error) {
+	var p documentSymbolParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	d, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	return d.symbols(), nil
+}
+
+// query decodes a position request and returns the document it is about
+func (s *Server) query(params json.RawMessage, p any) (*document, error) {
+	if err := json.Unmarshal(params, p); err != nil {
+		return nil, err
+	}
+	var uri string
+	switch p := p.(type) {
+	case *textDocumentPositionParams:
+		uri = p.TextDocument.URI
+	case *referenceParams:
+		uri = p.TextDocument.URI
+	case *renameParams:
+		uri = p.TextDocument.URI
+	}
+	return s.document(uri)
+}
+
+func (s *Server) definition(params json.RawMessage) (any, error) {
+	var p textDocumentPositionParams
+	d, err := s.query(params, &p)
+	if err != nil || d.index == nil {
+		return nil, err
+	}
+	def, ok := d.index.Definition(d.sourcePos(p.Position))
+	if !ok {
+		return nil, nil
+	}
+	return Location{URI: d.uri, Range: d.occurrenceRange(def)}, nil
+}
+
+func (s *Server) references(params json.RawMessage) (any, error) {
+	var p referenceParams
+	d, err := s.query(params, &p)
+	if err != nil || d.index == nil {
+		return nil, err
+	}
+	res := []Location{}
+	for _, o := range d.index.References(d.sourcePos(p.Position), p.Context.IncludeDeclaration) {
+		res = append(res, Location{URI: d.uri, Range: d.occurrenceRange(o)})
+	}
+	return res, nil
+}
+
+func (s *Server) prepareRename(params json.RawMessage) (any, error) {
+	var p textDocumentPositionParams
+	d, err := s.query(params, &p)
+	if err != nil || d.index == nil {
+		return nil, err
+	}
+	at, ok := d.index.At(d.sourcePos(p.Position))
+	if !ok {
+		return nil, nil
+	}
+	return d.occurrenceRange(at), nil
+}
+
+func (s *Server) rename(params json.RawMessage) (any, error) {
+	var p renameParams
+	d, err := s.query(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	if d.index == nil {
+		return nil, &ResponseError{Code: E_REQUEST_FAILED, Message: "the document does not parse"}
+	}
+	if d.partial {
+		return nil, &ResponseError{Code: E_REQUEST_FAILED, Message: "the #if branches of the document do not parse in place, so a rename could miss some of them"}
+	}
+	occurrences, err := d.index.Rename(d.sourcePos(p.Position), p.NewName)
+	if err != nil {
+		return nil, &ResponseError{Code: E_REQUEST_FAILED, Message: err.Error()}
+	}
+	if occurrences == nil {
+		return nil, nil
+	}
+	edits := make([]TextEdit, len(occurrences))
+	for i, o := range occurrences {
+		edits[i] = TextEdit{Range: d.occurrenceRange(o), NewText: p.NewName}
+	}
+	return WorkspaceEdit{Changes: map[string][]TextEdit{d.uri: edits}}, nil
+}
+
+func (s *Server) hover(params json.RawMessage) (any, error) {
+	var p textDocumentPositionParams
+	d, err := s.query(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	if h := d.hover(p.Position); h != nil {
+		return h, nil
+	}
+	return nil, nil
+}
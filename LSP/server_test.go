@@ -0,0 +1,226 @@
+package LSP_test
+
+import (
+	"braining/LSP"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// session runs a server with #if regions for defines over the messages and
+// returns what it sent back
+func session(t *testing.T, defines []string, messages ...any) ([]map[string]any, error) {
+	t.Helper()
+	in := bytes.Buffer{}
+	for _, m := range messages {
+		body, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+	out := bytes.Buffer{}
+	s := LSP.NewServer(&in, &out)
+	for _, name := range defines {
+		s.Define(name)
+	}
+	runErr := s.Run()
+
+	res := []map[string]any{}
+	r := bufio.NewReader(&out)
+	for {
+		header, err := textproto.NewReader(r).ReadMIMEHeader()
+		if err == io.EOF {
+			return res, runErr
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			t.Fatal(err)
+		}
+		var m map[string]any
+		if err := json.Unmarshal(body, &m); err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, m)
+	}
+}
+
+func request(id int, method string, params any) map[string]any {
+	return map[string]any{"jsonrpc": "2.0", "id": id, "method": method, "params": params}
+}
+
+func notification(method string, params any) map[string]any {
+	return map[string]any{"jsonrpc": "2.0", "method": method, "params": params}
+}
+
+// at builds the params of a request about a position in the test document
+func at(line, character int) map[string]any {
+	return map[string]any{
+		"textDocument": map[string]any{"uri": URI},
+		"position":     map[string]any{"line": line, "character": character},
+	}
+}
+
+const URI = "file:///test.br"
+
+// The emoji takes two UTF-16 code units, so characters after it on a line
+// are one more than their rune index
+const TEXT = `| 😀 | x = 1
+macro m takes a define
+a += 1
+emcro
+call m x
+| 😀 | write y
+write z
+`
+
+// reply finds the response to the request with id
+func reply(t *testing.T, messages []map[string]any, id int) any {
+	t.Helper()
+	for _, m := range messages {
+		if m["id"] == float64(id) {
+			if m["error"] != nil {
+				t.Fatalf("request %d failed: %v", id, m["error"])
+			}
+			return m["result"]
+		}
+	}
+	t.Fatalf("no reply to request %d", id)
+	return nil
+}
+
+// encode turns a result into JSON text for comparison
+func encode(t *testing.T, v any) string {
+	t.Helper()
+	body, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// diagnostics returns the last diagnostics published
+func diagnostics(messages []map[string]any) []any {
+	var res []any
+	for _, m := range messages {
+		if m["method"] == "textDocument/publishDiagnostics" {
+			res = m["params"].(map[string]any)["diagnostics"].([]any)
+		}
+	}
+	return res
+}
+
+func TestRoundTrip(t *testing.T) {
+	messages, err := session(t, nil,
+		request(1, "initialize", map[string]any{}),
+		notification("initialized", map[string]any{}),
+		notification("textDocument/didOpen", map[string]any{
+			"textDocument": map[string]any{"uri": URI, "version": 1, "text": TEXT},
+		}),
+		request(2, "textDocument/definition", at(4, 5)),
+		request(3, "textDocument/references", map[string]any{
+			"textDocument": map[string]any{"uri": URI},
+			"position":     map[string]any{"line": 0, "character": 7},
+			"context":      map[string]any{"includeDeclaration": true},
+		}),
+		request(4, "textDocument/rename", map[string]any{
+			"textDocument": map[string]any{"uri": URI},
+			"position":     map[string]any{"line": 4, "character": 7},
+			"newName":      "q",
+		}),
+		request(5, "shutdown", nil),
+		notification("exit", nil),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	capabilities := reply(t, messages, 1).(map[string]any)["capabilities"].(map[string]any)
+	if capabilities["positionEncoding"] != "utf-16" {
+		t.Errorf("position encoding %v", capabilities["positionEncoding"])
+	}
+
+	ranges := []string{}
+	for _, d := range diagnostics(messages) {
+		ranges = append(ranges, encode(t, d.(map[string]any)["range"]))
+	}
+	want := []string{
+		`{"end":{"character":14,"line":5},"start":{"character":13,"line":5}}`,
+		`{"end":{"character":7,"line":6},"start":{"character":6,"line":6}}`,
+	}
+	if strings.Join(ranges, "\n") != strings.Join(want, "\n") {
+		t.Errorf("diagnostics at\n%s\nwant\n%s", strings.Join(ranges, "\n"), strings.Join(want, "\n"))
+	}
+
+	tests := []struct {
+		id   int
+		want string
+	}{
+		{2, `{"range":{"end":{"character":7,"line":1},"start":{"character":6,"line":1}},"uri":"file:///test.br"}`},
+		{3, `[{"range":{"end":{"character":8,"line":0},"start":{"character":7,"line":0}},"uri":"file:///test.br"},` +
+			`{"range":{"end":{"character":8,"line":4},"start":{"character":7,"line":4}},"uri":"file:///test.br"}]`},
+		{4, `{"changes":{"file:///test.br":[{"newText":"q","range":{"end":{"character":8,"line":0},"start":{"character":7,"line":0}}},` +
+			`{"newText":"q","range":{"end":{"character":8,"line":4},"start":{"character":7,"line":4}}}]}}`},
+	}
+	for _, tt := range tests {
+		if got := encode(t, reply(t, messages, tt.id)); got != tt.want {
+			t.Errorf("request %d: got\n%s\nwant\n%s", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	messages, err := session(t, nil,
+		request(1, "textDocument/hover", at(0, 0)),
+		request(2, "initialize", map[string]any{}),
+		request(3, "no/such/method", nil),
+		notification("exit", nil),
+	)
+	if err == nil {
+		t.Error("exit before shutdown succeeded")
+	}
+	codes := []string{}
+	for _, m := range messages {
+		if e, ok := m["error"].(map[string]any); ok {
+			codes = append(codes, fmt.Sprint(m["id"], ":", e["code"]))
+		}
+	}
+	if got, want := strings.Join(codes, " "), "1:-32002 3:-32601"; got != want {
+		t.Errorf("errors %s, want %s", got, want)
+	}
+}
+
+func TestDefines(t *testing.T) {
+	text := "#if D\nx = 1\n#end\nwrite x\n"
+	tests := []struct {
+		defines []string
+		errors  int
+	}{
+		{nil, 1},
+		{[]string{"D"}, 0},
+	}
+	for _, tt := range tests {
+		messages, _ := session(t, tt.defines,
+			request(1, "initialize", map[string]any{}),
+			notification("textDocument/didOpen", map[string]any{
+				"textDocument": map[string]any{"uri": URI, "version": 1, "text": text},
+			}),
+		)
+		if got := len(diagnostics(messages)); got != tt.errors {
+			t.Errorf("defines %v: %d errors, want %d", tt.defines, got, tt.errors)
+		}
+	}
+}
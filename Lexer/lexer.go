@@ -3,6 +3,8 @@ package Lexer
 import (
 	"braining/Logging"
 	"regexp"
+	"slices"
+	"strings"
 	"unicode"
 )
 
@@ -12,10 +14,45 @@ type Lexer struct {
 	line             int
 	compiledPatterns []*regexp.Regexp
 	logger           *Logging.Logger
+	defines          map[string]bool
+	conds            []condFrame
+
+	// KeepTrivia records comments and directives in Comments, and every token
+	// in Tokens, and lexes every #if region as active, for tools that work on
+	// the text of a program rather than compile it
+	KeepTrivia bool
+	Comments   []Comment
+	Tokens     []Token
+
+	last Token
+}
+
+// Comment is a |comment| or a directive line, with its delimiters
+type Comment struct {
+	Text    string
+	Line    int // 1-based
+	Col     int
+	EndLine int
+	EndCol  int // Just past the last character
+}
+
+// condFrame tracks one open #if directive
+type condFrame struct {
+	active       bool
+	parentActive bool
+	seenElse     bool
+	line         int // 1-based
 }
 
 const COMMENT_SYMBOL = '|'
 
+const DIRECTIVE_SYMBOL = '#'
+
+const ASM_END_KEYWORD = "endasm"
+
+// EOF_VALUE is the value of the DONE token that ends the source
+const EOF_VALUE = "end of file"
+
 func NewLexer(source string, logger *Logging.Logger) *Lexer {
 	compiledPatterns := make([]*regexp.Regexp, len(TokenPatternJmp))
 
@@ -27,7 +64,66 @@ func NewLexer(source string, logger *Logging.Logger) *Lexer {
 		compiledPatterns[i] = regexp.MustCompile(string(pattern))
 	}
 
-	return &Lexer{source: []rune(source), pos: 0, compiledPatterns: compiledPatterns, logger: logger}
+	return &Lexer{source: []rune(source), pos: 0, compiledPatterns: compiledPatterns, logger: logger, defines: make(map[string]bool)}
+}
+
+// Define enables regions guarded by #if name
+func (l *Lexer) Define(name string) {
+	l.defines[name] = true
+}
+
+func (l *Lexer) active() bool {
+	return len(l.conds) == 0 || l.conds[len(l.conds)-1].active
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func (l *Lexer) readWord() string {
+	start := l.pos
+	for l.pos < len(l.source) && isWordRune(l.source[l.pos]) {
+		l.pos++
+	}
+	return string(l.source[start:l.pos])
+}
+
+func (l *Lexer) passDirective() {
+	start := l.pos
+	line, col := l.Position()
+	l.pos++
+	directive := l.readWord()
+	switch directive {
+	case "if":
+		for l.pos < len(l.source) && l.source[l.pos] != '\n' && unicode.IsSpace(l.source[l.pos]) {
+			l.pos++
+		}
+		name := l.readWord()
+		if name == "" {
+			err := Logging.InvalidDirectiveParserError{Directive: "#if", Line: l.Line()}
+			l.fail(&err, line, col)
+		}
+		parent := l.active()
+		l.conds = append(l.conds, condFrame{active: parent && (l.defines[name] || l.KeepTrivia), parentActive: parent, line: l.Line()})
+	case "else":
+		if len(l.conds) == 0 || l.conds[len(l.conds)-1].seenElse {
+			err := Logging.InvalidDirectiveParserError{Directive: "#else", Line: l.Line()}
+			l.fail(&err, line, col)
+		}
+		top := &l.conds[len(l.conds)-1]
+		top.active = top.parentActive && (!top.active || l.KeepTrivia)
+		top.seenElse = true
+	case "end":
+		if len(l.conds) == 0 {
+			err := Logging.InvalidDirectiveParserError{Directive: "#end", Line: l.Line()}
+			l.fail(&err, line, col)
+		}
+		l.conds = l.conds[:len(l.conds)-1]
+	default:
+		err := Logging.InvalidDirectiveParserError{Directive: "#" + directive, Line: l.Line()}
+		l.fail(&err, line, col)
+	}
+	l.record(start, line, col)
 }
 
 func (l *Lexer) matchToken(pattern int) (bool, string) {
@@ -36,6 +132,12 @@ func (l *Lexer) matchToken(pattern int) (bool, string) {
 	return match != "", match
 }
 
+// isCharLiteral reports whether a char literal such as '#' starts at the
+// current position, as P_LIT would match it
+func (l *Lexer) isCharLiteral() bool {
+	return l.pos+2 < len(l.source) && l.source[l.pos] == '\'' && l.source[l.pos+1] != '\n' && l.source[l.pos+2] == '\''
+}
+
 func (l *Lexer) passWhitespace() {
 	for l.pos < len(l.source) && unicode.IsSpace(l.source[l.pos]) {
 		if l.source[l.pos] == '\n' {
@@ -56,6 +158,8 @@ func (l *Lexer) passCommentsAndWhitespace() {
 		}
 
 		if l.source[l.pos] == COMMENT_SYMBOL {
+			start := l.pos
+			line, col := l.Position()
 			l.pos++
 			for l.pos < len(l.source) && l.source[l.pos] != COMMENT_SYMBOL {
 				if l.source[l.pos] == '\n' {
@@ -66,6 +170,24 @@ func (l *Lexer) passCommentsAndWhitespace() {
 			if l.pos < len(l.source) && l.source[l.pos] == COMMENT_SYMBOL {
 				l.pos++
 			}
+			l.record(start, line, col)
+			continue
+		}
+
+		if l.source[l.pos] == DIRECTIVE_SYMBOL {
+			l.passDirective()
+			continue
+		}
+
+		// Anything inside a disabled #if region is skipped without being
+		// tokenized, except that char literals are passed whole so that '#' and
+		// '|' are not taken for a directive or comment
+		if !l.active() {
+			if l.isCharLiteral() {
+				l.pos += 3
+			} else {
+				l.pos++
+			}
 			continue
 		}
 
@@ -77,17 +199,28 @@ func (l *Lexer) Advance() Token {
 	l.passCommentsAndWhitespace()
 
 	if l.pos >= len(l.source) {
-		return Token{T_DONE, string(P_DONE)}
+		line, col := l.Position()
+		if len(l.conds) > 0 {
+			err := Logging.InvalidDirectiveParserError{Directive: "#if", Line: l.conds[len(l.conds)-1].line}
+			l.fail(&err, l.conds[len(l.conds)-1].line, 1)
+		}
+		l.last = Token{Type: T_DONE, Value: EOF_VALUE, Line: line, Col: col}
+		return l.last
 	}
 
+	line, col := l.Position()
 	for i := range len(TokenPatternJmp) {
 		if ok, match := l.matchToken(i); ok {
+			l.last = Token{Type: TokenType(i), Value: match, Line: line, Col: col}
+			if l.KeepTrivia {
+				l.Tokens = append(l.Tokens, l.last)
+			}
 			if TokenType(i) == T_DONE {
 				l.pos = len(l.source)
-				return Token{T_DONE, match}
+				return l.last
 			}
-			l.pos += len(match)
-			return Token{TokenType(i), match}
+			l.pos += len([]rune(match))
+			return l.last
 		}
 	}
 
@@ -96,20 +229,99 @@ func (l *Lexer) Advance() Token {
 		unrec += string(l.source[l.pos])
 		l.pos++
 	}
-	err := Logging.InvalidIdentifierParserError{Name: unrec, Line: l.line}
-	l.logger.Error(err.Error())
-	return Token{T_DONE, string(P_DONE)}
+	err := Logging.InvalidIdentifierParserError{Name: unrec, Line: l.Line()}
+	l.fail(&err, line, col)
+	return Token{Type: T_DONE, Value: EOF_VALUE, Line: line, Col: col}
+}
+
+// ReadRaw returns the source text up to the next terminator and moves past
+// it, without tokenizing anything in between. The terminator only counts as a
+// whole word outside |comments|. It is used for bodies that are not braining
+// code, such as inline asm blocks.
+func (l *Lexer) ReadRaw(terminator string) (string, bool) {
+	word := []rune(terminator)
+	for i := l.pos; i < len(l.source); i++ {
+		if l.source[i] == COMMENT_SYMBOL {
+			end := slices.Index(l.source[i+1:], COMMENT_SYMBOL)
+			if end < 0 {
+				return "", false
+			}
+			i += end + 1
+			continue
+		}
+		if l.wordAt(i, word) {
+			raw := string(l.source[l.pos:i])
+			l.line += strings.Count(raw, "\n")
+			l.pos = i + len(word)
+			return raw, true
+		}
+	}
+	return "", false
+}
+
+// wordAt reports whether word stands on its own at position i
+func (l *Lexer) wordAt(i int, word []rune) bool {
+	end := i + len(word)
+	if end > len(l.source) || !slices.Equal(l.source[i:end], word) {
+		return false
+	}
+	return (i == 0 || !isWordRune(l.source[i-1])) && (end == len(l.source) || !isWordRune(l.source[end]))
 }
 
 func (l *Lexer) Peek() Token {
 	pos := l.pos
 	line := l.line
+	conds := append([]condFrame(nil), l.conds...)
+	comments := len(l.Comments)
+	tokens := len(l.Tokens)
+	last := l.last
 	token := l.Advance()
 	l.pos = pos
 	l.line = line
+	l.conds = conds
+	l.Comments = l.Comments[:comments]
+	l.Tokens = l.Tokens[:tokens]
+	l.last = last
 	return token
 }
 
+// record keeps the comment or directive from start to the current position
+func (l *Lexer) record(start, line, col int) {
+	if !l.KeepTrivia {
+		return
+	}
+	endLine, endCol := l.Position()
+	l.Comments = append(l.Comments, Comment{
+		Text:    strings.TrimRight(string(l.source[start:l.pos]), " \t\r"),
+		Line:    line,
+		Col:     col,
+		EndLine: endLine,
+		EndCol:  endCol,
+	})
+}
+
+// Last returns the token the last call to Advance returned
+func (l *Lexer) Last() Token {
+	return l.last
+}
+
+// fail reports err for the source from line and col up to the current position
+func (l *Lexer) fail(err error, line, col int) {
+	endLine, endCol := l.Position()
+	l.logger.Raise(&Logging.SourceError{Err: err, Line: line, Col: col, EndLine: endLine, EndCol: endCol})
+}
+
+// Line returns the 1-based line the lexer is on
 func (l *Lexer) Line() int {
-	return l.line
+	return l.line + 1
+}
+
+// Position returns the 1-based line and column the lexer is at, which is just
+// past the last token read
+func (l *Lexer) Position() (int, int) {
+	start := l.pos
+	for start > 0 && l.source[start-1] != '\n' {
+		start--
+	}
+	return l.line + 1, l.pos - start + 1
 }
@@ -17,6 +17,8 @@ const (
 	T_MACRO_END
 	T_MACRO_CALL
 	T_BREAKPOINT
+	T_ASM
+	T_ASM_END
 
 	T_IDENT
 	T_LIT
@@ -30,20 +32,22 @@ type TokenPattern string
 
 const (
 	// Keywords
-	P_IF               TokenPattern = `^if`
-	P_NOT              TokenPattern = `^not`
-	P_WHILE            TokenPattern = `^while`
-	P_END              TokenPattern = `^end`
-	P_DONE             TokenPattern = `^done`
-	P_WRITE            TokenPattern = `^write`
-	P_READ             TokenPattern = `^read`
-	P_FREE             TokenPattern = `^free`
-	P_MACRO_BEGIN      TokenPattern = `^macro`
-	P_MACRO_SET_PARAMS TokenPattern = `^takes`
-	P_MACRO_DEFINE     TokenPattern = `^define`
-	P_MACRO_END        TokenPattern = `^emcro`
-	P_MACRO_CALL       TokenPattern = `^call`
-	P_BREAKPOINT       TokenPattern = `^breakpoint`
+	P_IF               TokenPattern = `^if\b`
+	P_NOT              TokenPattern = `^not\b`
+	P_WHILE            TokenPattern = `^while\b`
+	P_END              TokenPattern = `^end\b`
+	P_DONE             TokenPattern = `^done\b`
+	P_WRITE            TokenPattern = `^write\b`
+	P_READ             TokenPattern = `^read\b`
+	P_FREE             TokenPattern = `^free\b`
+	P_MACRO_BEGIN      TokenPattern = `^macro\b`
+	P_MACRO_SET_PARAMS TokenPattern = `^takes\b`
+	P_MACRO_DEFINE     TokenPattern = `^define\b`
+	P_MACRO_END        TokenPattern = `^emcro\b`
+	P_MACRO_CALL       TokenPattern = `^call\b`
+	P_BREAKPOINT       TokenPattern = `^breakpoint\b`
+	P_ASM              TokenPattern = `^asm\b`
+	P_ASM_END          TokenPattern = `^endasm\b`
 
 	// Identifiers and literals
 	P_IDENT TokenPattern = `^[a-zA-Z_][a-zA-Z0-9_]*`
@@ -55,9 +59,20 @@ const (
 	P_SUB    TokenPattern = `^-=`
 )
 
-var TokenPatternJmp = [...]TokenPattern{P_IF, P_NOT, P_WHILE, P_END, P_DONE, P_WRITE, P_READ, P_FREE, P_MACRO_BEGIN, P_MACRO_SET_PARAMS, P_MACRO_DEFINE, P_MACRO_END, P_MACRO_CALL, P_BREAKPOINT, P_IDENT, P_LIT, P_ASSIGN, P_ADD, P_SUB}
+var TokenPatternJmp = [...]TokenPattern{P_IF, P_NOT, P_WHILE, P_END, P_DONE, P_WRITE, P_READ, P_FREE, P_MACRO_BEGIN, P_MACRO_SET_PARAMS, P_MACRO_DEFINE, P_MACRO_END, P_MACRO_CALL, P_BREAKPOINT, P_ASM, P_ASM_END, P_IDENT, P_LIT, P_ASSIGN, P_ADD, P_SUB}
+
+var tokenNames = [...]string{"IF", "NOT", "WHILE", "END", "DONE", "WRITE", "READ", "FREE", "MACRO_BEGIN", "MACRO_SET_PARAMS", "MACRO_DEFINE", "MACRO_END", "MACRO_CALL", "BREAKPOINT", "ASM", "ASM_END", "IDENT", "LIT", "ASSIGN", "ADD", "SUB"}
+
+func (t TokenType) String() string {
+	if t < 0 || int(t) >= len(tokenNames) {
+		return "UNKNOWN"
+	}
+	return tokenNames[t]
+}
 
 type Token struct {
 	Type  TokenType
 	Value string
+	Line  int // 1-based line the token starts on
+	Col   int // 1-based column the token starts at
 }
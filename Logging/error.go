@@ -7,6 +7,10 @@ type ErrorType int
 const (
 	E_PARSER = iota
 	E_COMPILER
+	E_INTERPRETER
+	E_QUERY
+	E_EVALUATOR
+	E_FORMATTER
 )
 
 type Error interface {
@@ -14,6 +18,24 @@ type Error interface {
 	Type() ErrorType
 }
 
+// SourceError ties an error to the source it is about. Lines and columns are
+// 1-based, and the range ends just past its last character.
+type SourceError struct {
+	Err     error
+	Line    int
+	Col     int
+	EndLine int
+	EndCol  int
+}
+
+func (e *SourceError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
+
 // Errors for invalid literals
 
 type InvalidLiteralParserError struct {
@@ -22,7 +44,7 @@ type InvalidLiteralParserError struct {
 }
 
 func (e *InvalidLiteralParserError) Error() string {
-	return "(PARSER) Invalid Literal: " + e.Value + " at line " + string(e.Line)
+	return fmt.Sprintf("(PARSER) Invalid Literal: %s at line %d", e.Value, e.Line)
 }
 
 func (e *InvalidLiteralParserError) Type() ErrorType {
@@ -105,3 +127,241 @@ type InvalidRightParserError struct {
 func (e *InvalidRightParserError) Error() string {
 	return fmt.Sprintf("(PARSER) Invalid right-hand side of an assignment at line %d", e.Line)
 }
+
+// LiteralArgumentParserError is a literal passed to a macro parameter that the
+// macro changes, tests or otherwise uses as a variable
+type LiteralArgumentParserError struct {
+	Param string
+	Value string
+}
+
+func (e *LiteralArgumentParserError) Error() string {
+	return fmt.Sprintf("(PARSER) Macro parameter %s is used as a variable, so it cannot take the literal %s", e.Param, e.Value)
+}
+
+func (e *LiteralArgumentParserError) Type() ErrorType {
+	return E_PARSER
+}
+
+// Errors for inline asm blocks
+
+type UnterminatedAsmParserError struct {
+	Line int
+}
+
+func (e *UnterminatedAsmParserError) Error() string {
+	return fmt.Sprintf("(PARSER) Unterminated asm block starting at line %d", e.Line)
+}
+
+func (e *UnterminatedAsmParserError) Type() ErrorType {
+	return E_PARSER
+}
+
+type UnbalancedAsmCompilerError struct {
+	Reason string
+}
+
+func (e *UnbalancedAsmCompilerError) Error() string {
+	return fmt.Sprintf("(COMPILER) Unbalanced asm block: %s", e.Reason)
+}
+
+func (e *UnbalancedAsmCompilerError) Type() ErrorType {
+	return E_COMPILER
+}
+
+// UnboundCellAsmCompilerError is an asm block changing, testing or doing I/O
+// past the ends of its bindings, on cells that may belong to anything
+type UnboundCellAsmCompilerError struct {
+	Op     string
+	Offset int // In cells from the first binding
+	Origin string
+}
+
+func (e *UnboundCellAsmCompilerError) Error() string {
+	return fmt.Sprintf("(COMPILER) Asm block uses %s on a cell that is not bound, %+d from %s", e.Op, e.Offset, e.Origin)
+}
+
+func (e *UnboundCellAsmCompilerError) Type() ErrorType {
+	return E_COMPILER
+}
+
+// Errors for conditional compilation directives
+
+type InvalidDirectiveParserError struct {
+	Directive string
+	Line      int
+}
+
+func (e *InvalidDirectiveParserError) Error() string {
+	return fmt.Sprintf("(PARSER) Unmatched or invalid directive %s at line %d", e.Directive, e.Line)
+}
+
+func (e *InvalidDirectiveParserError) Type() ErrorType {
+	return E_PARSER
+}
+
+// Errors for unsupported compilation targets
+
+type InvalidTargetCompilerError struct {
+	Reason string
+}
+
+func (e *InvalidTargetCompilerError) Error() string {
+	return fmt.Sprintf("(COMPILER) Unsupported target: %s", e.Reason)
+}
+
+func (e *InvalidTargetCompilerError) Type() ErrorType {
+	return E_COMPILER
+}
+
+// Errors for output dialects
+
+type InvalidDialectCompilerError struct {
+	Reason string
+}
+
+func (e *InvalidDialectCompilerError) Error() string {
+	return fmt.Sprintf("(COMPILER) Invalid output dialect: %s", e.Reason)
+}
+
+func (e *InvalidDialectCompilerError) Type() ErrorType {
+	return E_COMPILER
+}
+
+// Errors for running Brainf***
+
+type UnmatchedBracketInterpreterError struct {
+	Offset int
+}
+
+func (e *UnmatchedBracketInterpreterError) Error() string {
+	return fmt.Sprintf("(INTERPRETER) Unmatched bracket at offset %d", e.Offset)
+}
+
+func (e *UnmatchedBracketInterpreterError) Type() ErrorType {
+	return E_INTERPRETER
+}
+
+type TapeBoundsInterpreterError struct {
+	Pointer int
+	Offset  int
+}
+
+func (e *TapeBoundsInterpreterError) Error() string {
+	return fmt.Sprintf("(INTERPRETER) Pointer moved off the tape to %d at offset %d", e.Pointer, e.Offset)
+}
+
+func (e *TapeBoundsInterpreterError) Type() ErrorType {
+	return E_INTERPRETER
+}
+
+type CellRangeInterpreterError struct {
+	Value   int
+	Pointer int
+	Offset  int
+}
+
+func (e *CellRangeInterpreterError) Error() string {
+	return fmt.Sprintf("(INTERPRETER) Cell %d went out of range to %d at offset %d", e.Pointer, e.Value, e.Offset)
+}
+
+func (e *CellRangeInterpreterError) Type() ErrorType {
+	return E_INTERPRETER
+}
+
+type StepLimitInterpreterError struct {
+	Limit int
+}
+
+func (e *StepLimitInterpreterError) Error() string {
+	return fmt.Sprintf("(INTERPRETER) Step limit of %d reached", e.Limit)
+}
+
+func (e *StepLimitInterpreterError) Type() ErrorType {
+	return E_INTERPRETER
+}
+
+// Errors for source queries
+
+type InvalidRenameQueryError struct {
+	Name   string
+	Reason string
+}
+
+func (e *InvalidRenameQueryError) Error() string {
+	return fmt.Sprintf("(QUERY) Cannot rename to %s: %s", e.Name, e.Reason)
+}
+
+func (e *InvalidRenameQueryError) Type() ErrorType {
+	return E_QUERY
+}
+
+// Errors for evaluating programs from their AST
+
+type InvalidLiteralEvaluatorError struct {
+	Value string
+}
+
+func (e *InvalidLiteralEvaluatorError) Error() string {
+	return "(EVALUATOR) Invalid Literal: " + e.Value
+}
+
+func (e *InvalidLiteralEvaluatorError) Type() ErrorType {
+	return E_EVALUATOR
+}
+
+type UnsupportedEvaluatorError struct {
+	Statement string
+}
+
+func (e *UnsupportedEvaluatorError) Error() string {
+	return fmt.Sprintf("(EVALUATOR) Cannot evaluate %s statements", e.Statement)
+}
+
+func (e *UnsupportedEvaluatorError) Type() ErrorType {
+	return E_EVALUATOR
+}
+
+type OverflowEvaluatorError struct {
+	Name string
+}
+
+func (e *OverflowEvaluatorError) Error() string {
+	return fmt.Sprintf("(EVALUATOR) %s went past the largest value of a cell", e.Name)
+}
+
+func (e *OverflowEvaluatorError) Type() ErrorType {
+	return E_EVALUATOR
+}
+
+type StepLimitEvaluatorError struct {
+	Limit int
+}
+
+func (e *StepLimitEvaluatorError) Error() string {
+	return fmt.Sprintf("(EVALUATOR) Step limit of %d reached", e.Limit)
+}
+
+func (e *StepLimitEvaluatorError) Type() ErrorType {
+	return E_EVALUATOR
+}
+
+// Errors for formatting programs
+
+// BranchesFormatterError is a program that only parses with some of its #if
+// branches left out, while formatting needs every branch to parse in place
+type BranchesFormatterError struct {
+	Err error
+}
+
+func (e *BranchesFormatterError) Error() string {
+	return fmt.Sprintf("(FORMATTER) Cannot format #if branches that hold parts of statements, such as a loop header whose end is outside the branch: %v", e.Err)
+}
+
+func (e *BranchesFormatterError) Unwrap() error {
+	return e.Err
+}
+
+func (e *BranchesFormatterError) Type() ErrorType {
+	return E_FORMATTER
+}
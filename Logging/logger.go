@@ -20,6 +20,41 @@ type Logger struct {
 	minSeverity LogSeverity
 	colors      map[LogSeverity]string
 	name        string
+
+	// Recoverable makes Error panic with an Abort instead of exiting, so that
+	// interactive tools can report the error and carry on; see Catch
+	Recoverable bool
+}
+
+// Abort is the panic value raised by Error and Raise on a recoverable logger.
+// Err is the error passed to Raise, if that is how it was raised.
+type Abort struct {
+	Message string
+	Err     error
+}
+
+func (a Abort) Error() string {
+	return a.Message
+}
+
+func (a Abort) Unwrap() error {
+	return a.Err
+}
+
+// Catch runs f and returns the Abort raised by a recoverable logger inside it,
+// if any. Other panics are passed on.
+func Catch(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			abort, ok := r.(Abort)
+			if !ok {
+				panic(r)
+			}
+			err = abort
+		}
+	}()
+	f()
+	return nil
 }
 
 func NewLogger(name string, minSeverity LogSeverity, colors map[LogSeverity]string) *Logger {
@@ -37,19 +72,39 @@ func NewLoggerWithDefaultColors(name string, minSeverity LogSeverity) *Logger {
 	return NewLogger(name, minSeverity, defaultColors)
 }
 
+// NewRecoverableLogger returns a logger that prints nothing and makes Error
+// panic with an Abort, for callers that report errors themselves
+func NewRecoverableLogger(name string) *Logger {
+	l := NewLogger(name, ERROR+1, nil)
+	l.Recoverable = true
+	return l
+}
+
 func (l *Logger) log(severity LogSeverity, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	l.print(severity, msg)
+	if severity == ERROR {
+		l.abort(Abort{Message: msg})
+	}
+}
+
+func (l *Logger) print(severity LogSeverity, msg string) {
 	if severity >= l.minSeverity {
-		msg := fmt.Sprintf(format, args...)
 		timestamp := time.Now().Format(time.RFC3339)
 		color := l.colors[severity]
 		resetColor := "\033[0m"
 		fmt.Printf("%s[%s] [%s] %s%s\n", color, timestamp, severity.String(), msg, resetColor)
-		if severity == ERROR {
-			os.Exit(1)
-		}
 	}
 }
 
+// abort ends the process, or panics with a when the logger is recoverable
+func (l *Logger) abort(a Abort) {
+	if l.Recoverable {
+		panic(a)
+	}
+	os.Exit(1)
+}
+
 func (l *Logger) Debug(format string, args ...interface{}) {
 	l.log(DEBUG, format, args...)
 }
@@ -70,6 +125,13 @@ func (l *Logger) Error(format string, args ...interface{}) {
 	l.log(ERROR, format, args...)
 }
 
+// Raise logs err like Error, keeping err itself in the Abort of a recoverable
+// logger so that callers can tell what went wrong and where
+func (l *Logger) Raise(err error) {
+	l.print(ERROR, err.Error())
+	l.abort(Abort{Message: err.Error(), Err: err})
+}
+
 func (severity LogSeverity) String() string {
 	switch severity {
 	case DEBUG:
@@ -5,12 +5,16 @@ import (
 	"braining/Lexer"
 	"braining/Logging"
 	"fmt"
+	"maps"
+	"strings"
+	"unicode/utf8"
 )
 
 type Parser struct {
 	lexer      *Lexer.Lexer
 	blockStack []*AST.BlockNode
 	macros     map[string]*AST.MacroNode
+	defines    []string
 	Ast        AST.Ast
 	logger     *Logging.Logger
 	pos        int
@@ -30,11 +34,43 @@ func NewParser(source string, logger *Logging.Logger) *Parser {
 	return p
 }
 
-func (p *Parser) copyToken(t AST.Token, tbl map[AST.IdentToken]AST.Token) AST.Token {
+// KeepTrivia makes Parse keep comments and directives in Ast.Comments and the
+// positions of tokens in Ast.Tokens, and parse every #if region, for tools that
+// rewrite or inspect the source. It must be called before Parse.
+func (p *Parser) KeepTrivia() {
+	p.lexer.KeepTrivia = true
+}
+
+// Define enables regions guarded by #if name; it must be called before Parse
+func (p *Parser) Define(name string) {
+	p.defines = append(p.defines, name)
+	p.lexer.Define(name)
+}
+
+// copyIdent copies an identifier that names a variable. A macro parameter used
+// that way cannot take a literal argument.
+func (p *Parser) copyIdent(id *AST.IdentToken, tbl map[string]AST.Token) AST.IdentToken {
+	t := p.copyToken(id, tbl)
+	if t.Type() == AST.T_IDENT {
+		return *t.(*AST.IdentToken)
+	}
+	lit := t.(*AST.LitToken)
+	err := Logging.LiteralArgumentParserError{Param: id.Name, Value: lit.Text}
+	p.logger.Raise(&Logging.SourceError{
+		Err:     &err,
+		Line:    lit.Pos.Line,
+		Col:     lit.Pos.Col,
+		EndLine: lit.Pos.Line,
+		EndCol:  lit.Pos.Col + len([]rune(lit.Text)),
+	})
+	return AST.IdentToken{}
+}
+
+func (p *Parser) copyToken(t AST.Token, tbl map[string]AST.Token) AST.Token {
 	switch t.Type() {
 	case AST.T_IDENT:
 		id := t.(*AST.IdentToken)
-		if val, ok := tbl[*id]; ok {
+		if val, ok := tbl[id.Name]; ok {
 			return val
 		}
 		return id
@@ -45,44 +81,152 @@ func (p *Parser) copyToken(t AST.Token, tbl map[AST.IdentToken]AST.Token) AST.To
 	}
 }
 
-func parseLit(lit string) AST.Token {
+func parseLit(t Lexer.Token) AST.Token {
+	lit := t.Value
 	if len(lit) == 3 && lit[0] == '\'' && lit[2] == '\'' {
-		return &AST.LitToken{Value: fmt.Sprintf("%d", lit[1])}
+		return &AST.LitToken{Value: fmt.Sprintf("%d", lit[1]), Text: lit, Pos: tokenPos(t)}
+	}
+	return &AST.LitToken{Value: lit, Text: lit, Pos: tokenPos(t)}
+}
+
+func tokenPos(t Lexer.Token) AST.Pos {
+	return AST.Pos{Line: t.Line, Col: t.Col}
+}
+
+func ident(t Lexer.Token) AST.IdentToken {
+	return AST.IdentToken{Name: t.Value, Pos: tokenPos(t)}
+}
+
+// span covers a statement from its first token up to the last token read
+func (p *Parser) span(start Lexer.Token) AST.Span {
+	line, col := p.lexer.Position()
+	return AST.Span{Start: tokenPos(start), End: AST.Pos{Line: line, Col: col}}
+}
+
+// parseAsmBody splits a raw asm body, which starts at start, on its {name}
+// references. Every reference must name one of the block's bindings.
+// Braces inside |comments| are not references.
+func (p *Parser) parseAsmBody(raw string, start AST.Pos, bindings []AST.IdentToken) []AST.AsmPart {
+	bound := make(map[string]bool)
+	for _, b := range bindings {
+		bound[b.Name] = true
+	}
+
+	parts := []AST.AsmPart{}
+	cur := AST.AsmPart{}
+	pos := start
+	code := blankComments(raw)
+	for {
+		open := strings.IndexRune(code, '{')
+		if open < 0 {
+			cur.Code += raw
+			break
+		}
+		cur.Code += raw[:open]
+		end := strings.IndexRune(code[open:], '}')
+		if end < 0 {
+			err := Logging.UnterminatedAsmParserError{Line: p.lexer.Line()}
+			p.fail(&err)
+		}
+		inner := raw[open+1 : open+end]
+		name := strings.TrimSpace(inner)
+		if !bound[name] {
+			err := Logging.InvalidIdentifierParserError{Name: name, Line: p.lexer.Line()}
+			p.fail(&err)
+		}
+		if cur.Ref.Name != "" || cur.Code != "" {
+			parts = append(parts, cur)
+		}
+		namePos := advancePos(pos, raw[:open+1]+inner[:strings.Index(inner, name)])
+		cur = AST.AsmPart{Ref: AST.IdentToken{Name: name, Pos: namePos}}
+		pos = advancePos(pos, raw[:open+end+1])
+		raw = raw[open+end+1:]
+		code = code[open+end+1:]
+	}
+	if cur.Ref.Name != "" || cur.Code != "" {
+		parts = append(parts, cur)
+	}
+	return parts
+}
+
+// blankComments replaces the |comments| in text with spaces, byte for byte,
+// so that what is found in the result has the same index in text
+func blankComments(text string) string {
+	sb := strings.Builder{}
+	comment := false
+	for _, r := range text {
+		if r == Lexer.COMMENT_SYMBOL {
+			comment = !comment
+		} else if !comment {
+			sb.WriteRune(r)
+			continue
+		}
+		sb.WriteString(strings.Repeat(" ", utf8.RuneLen(r)))
+	}
+	return sb.String()
+}
+
+// advancePos returns the position just past text, which starts at pos
+func advancePos(pos AST.Pos, text string) AST.Pos {
+	for _, r := range text {
+		if r == '\n' {
+			pos = AST.Pos{Line: pos.Line + 1, Col: 1}
+		} else {
+			pos.Col++
+		}
+	}
+	return pos
+}
+
+// fail reports err at the last token read
+func (p *Parser) fail(err error) {
+	t := p.lexer.Last()
+	end := t.Col + len([]rune(t.Value))
+	if t.Type == Lexer.T_DONE && t.Value == Lexer.EOF_VALUE {
+		end = t.Col
 	}
-	return &AST.LitToken{Value: lit}
+	p.logger.Raise(&Logging.SourceError{Err: err, Line: t.Line, Col: t.Col, EndLine: t.Line, EndCol: end})
 }
 
 func (p *Parser) appendNode(n AST.Node) {
 	p.blockStack[len(p.blockStack)-1].Nodes = append(p.blockStack[len(p.blockStack)-1].Nodes, n)
+	var body *AST.BlockNode
 	switch n.Type() {
 	case AST.N_BLOCK:
 		p.blockStack = append(p.blockStack, n.(*AST.BlockNode))
+		return
 	case AST.N_IF:
-		p.blockStack = append(p.blockStack, &n.(*AST.IfNode).Block)
+		body = &n.(*AST.IfNode).Block
 	case AST.N_IFNOT:
-		p.blockStack = append(p.blockStack, &n.(*AST.IfNotNode).Block)
+		body = &n.(*AST.IfNotNode).Block
 	case AST.N_WHILE:
-		p.blockStack = append(p.blockStack, &n.(*AST.WhileNode).Block)
+		body = &n.(*AST.WhileNode).Block
 	case AST.N_WHILENOT:
-		p.blockStack = append(p.blockStack, &n.(*AST.WhileNotNode).Block)
+		body = &n.(*AST.WhileNotNode).Block
 	case AST.N_MACRO:
-		p.blockStack = append(p.blockStack, &n.(*AST.MacroNode).Block)
+		body = &n.(*AST.MacroNode).Block
+	default:
+		return
 	}
+	body.Start = n.Position().End
+	p.blockStack = append(p.blockStack, body)
 }
 
-func (p *Parser) popBlock() {
+// popBlock closes the innermost body at its end or emcro token
+func (p *Parser) popBlock(closing Lexer.Token) {
 	if len(p.blockStack) < 2 {
 		err := Logging.InvalidEndParserError{Line: p.lexer.Line()}
-		p.logger.Error(err.Error())
+		p.fail(&err)
 	}
+	p.blockStack[len(p.blockStack)-1].End = tokenPos(closing)
 	p.blockStack = p.blockStack[:len(p.blockStack)-1]
 }
 
-func (p *Parser) copyNode(n AST.Node, tbl map[AST.IdentToken]AST.Token) AST.Node {
+func (p *Parser) copyNode(n AST.Node, tbl map[string]AST.Token) AST.Node {
 	switch n.Type() {
 	case AST.N_BLOCK:
 		b := n.(*AST.BlockNode)
-		res := &AST.BlockNode{Nodes: make([]AST.Node, len(b.Nodes))}
+		res := &AST.BlockNode{Span: b.Span, Nodes: make([]AST.Node, len(b.Nodes)), Call: b.Call}
 		for i, node := range b.Nodes {
 			res.Nodes[i] = p.copyNode(node, tbl)
 		}
@@ -90,63 +234,71 @@ func (p *Parser) copyNode(n AST.Node, tbl map[AST.IdentToken]AST.Token) AST.Node
 	case AST.N_ASSIGN:
 		a := n.(*AST.AssignNode)
 		return &AST.AssignNode{
-			Left:  *p.copyToken(&a.Left, tbl).(*AST.IdentToken),
+			Span:  a.Span,
+			Left:  p.copyIdent(&a.Left, tbl),
 			Right: p.copyToken(a.Right, tbl),
 		}
 	case AST.N_ADD:
 		a := n.(*AST.AddNode)
 		return &AST.AddNode{
-			Left:  *p.copyToken(&a.Left, tbl).(*AST.IdentToken),
+			Span:  a.Span,
+			Left:  p.copyIdent(&a.Left, tbl),
 			Right: p.copyToken(a.Right, tbl),
 		}
 	case AST.N_SUB:
 		s := n.(*AST.SubNode)
 		return &AST.SubNode{
-			Left:  *p.copyToken(&s.Left, tbl).(*AST.IdentToken),
+			Span:  s.Span,
+			Left:  p.copyIdent(&s.Left, tbl),
 			Right: p.copyToken(s.Right, tbl),
 		}
 	case AST.N_IF:
 		i := n.(*AST.IfNode)
 		b := p.copyNode(&i.Block, tbl).(*AST.BlockNode)
 		return &AST.IfNode{
-			Id:    *p.copyToken(&i.Id, tbl).(*AST.IdentToken),
+			Span:  i.Span,
+			Id:    p.copyIdent(&i.Id, tbl),
 			Block: *b,
 		}
 	case AST.N_IFNOT:
 		i := n.(*AST.IfNotNode)
 		b := p.copyNode(&i.Block, tbl).(*AST.BlockNode)
 		return &AST.IfNotNode{
-			Id:    *p.copyToken(&i.Id, tbl).(*AST.IdentToken),
+			Span:  i.Span,
+			Id:    p.copyIdent(&i.Id, tbl),
 			Block: *b,
 		}
 	case AST.N_WHILE:
 		w := n.(*AST.WhileNode)
 		b := p.copyNode(&w.Block, tbl).(*AST.BlockNode)
 		return &AST.WhileNode{
-			Id:    *p.copyToken(&w.Id, tbl).(*AST.IdentToken),
+			Span:  w.Span,
+			Id:    p.copyIdent(&w.Id, tbl),
 			Block: *b,
 		}
 	case AST.N_WHILENOT:
 		w := n.(*AST.WhileNotNode)
 		b := p.copyNode(&w.Block, tbl).(*AST.BlockNode)
 		return &AST.WhileNotNode{
-			Id:    *p.copyToken(&w.Id, tbl).(*AST.IdentToken),
+			Span:  w.Span,
+			Id:    p.copyIdent(&w.Id, tbl),
 			Block: *b,
 		}
 	case AST.N_WRITE:
 		w := n.(*AST.WriteNode)
-		return &AST.WriteNode{Value: p.copyToken(w.Value, tbl)}
+		return &AST.WriteNode{Span: w.Span, Value: p.copyToken(w.Value, tbl)}
 	case AST.N_READ:
 		r := n.(*AST.ReadNode)
-		return &AST.ReadNode{Value: *p.copyToken(&r.Value, tbl).(*AST.IdentToken)}
+		return &AST.ReadNode{Span: r.Span, Value: p.copyIdent(&r.Value, tbl)}
 	case AST.N_FREE:
 		f := n.(*AST.FreeNode)
-		return &AST.FreeNode{Value: *p.copyToken(&f.Value, tbl).(*AST.IdentToken)}
+		return &AST.FreeNode{Span: f.Span, Value: p.copyIdent(&f.Value, tbl)}
 	case AST.N_MACRO:
 		m := n.(*AST.MacroNode)
 		b := p.copyNode(&m.Block, tbl).(*AST.BlockNode)
 		return &AST.MacroNode{
-			Name:   *p.copyToken(&m.Name, tbl).(*AST.IdentToken),
+			Span:   m.Span,
+			Name:   p.copyIdent(&m.Name, tbl),
 			Params: m.Params,
 			Block:  *b,
 		}
@@ -154,15 +306,36 @@ func (p *Parser) copyNode(n AST.Node, tbl map[AST.IdentToken]AST.Token) AST.Node
 		mc := n.(*AST.MacroCallNode)
 
 		macro := p.macros[mc.Name.Name]
-		newTbl := make(map[AST.IdentToken]AST.Token)
+		newTbl := make(map[string]AST.Token)
 		for _, param := range macro.Params {
-			newTbl[param] = p.copyToken(mc.Args[param], tbl)
+			newTbl[param.Name] = p.copyToken(mc.Args[param.Name], tbl)
 		}
 
+		// The expansion is attributed to the call, its statements to the macro body
 		macroBlock := p.copyNode(&macro.Block, newTbl).(*AST.BlockNode)
+		macroBlock.Span = mc.Span
+		macroBlock.Call = mc
 		return macroBlock
 	case AST.N_BREAKPOINT:
-		return &AST.BreakpointNode{}
+		return &AST.BreakpointNode{Span: n.Position()}
+	case AST.N_ASM:
+		a := n.(*AST.AsmNode)
+		res := &AST.AsmNode{
+			Span:     a.Span,
+			Bindings: make([]AST.IdentToken, len(a.Bindings)),
+			Parts:    make([]AST.AsmPart, len(a.Parts)),
+			Raw:      a.Raw,
+		}
+		for i, binding := range a.Bindings {
+			res.Bindings[i] = p.copyIdent(&binding, tbl)
+		}
+		for i, part := range a.Parts {
+			res.Parts[i] = AST.AsmPart{Code: part.Code}
+			if part.Ref.Name != "" {
+				res.Parts[i].Ref = p.copyIdent(&part.Ref, tbl)
+			}
+		}
+		return res
 	}
 	return nil
 }
@@ -176,15 +349,15 @@ func (p *Parser) parseNext() bool {
 			id = p.lexer.Advance()
 			if id.Type != Lexer.T_IDENT {
 				err := Logging.InvalidIdentifierParserError{Name: id.Value, Line: p.lexer.Line()}
-				p.logger.Error(err.Error())
+				p.fail(&err)
 			}
-			i := AST.IfNotNode{Id: AST.IdentToken{Name: id.Value}}
+			i := AST.IfNotNode{Span: p.span(t), Id: ident(id)}
 			p.appendNode(&i)
 		} else if id.Type != Lexer.T_IDENT {
 			err := Logging.InvalidIdentifierParserError{Name: id.Value, Line: p.lexer.Line()}
-			p.logger.Error(err.Error())
+			p.fail(&err)
 		} else {
-			i := AST.IfNode{Id: AST.IdentToken{Name: id.Value}}
+			i := AST.IfNode{Span: p.span(t), Id: ident(id)}
 			p.appendNode(&i)
 		}
 
@@ -194,25 +367,38 @@ func (p *Parser) parseNext() bool {
 			id = p.lexer.Advance()
 			if id.Type != Lexer.T_IDENT {
 				err := Logging.InvalidIdentifierParserError{Name: id.Value, Line: p.lexer.Line()}
-				p.logger.Error(err.Error())
+				p.fail(&err)
 			}
-			w := AST.WhileNotNode{Id: AST.IdentToken{Name: id.Value}}
+			w := AST.WhileNotNode{Span: p.span(t), Id: ident(id)}
 			p.appendNode(&w)
 		} else if id.Type != Lexer.T_IDENT {
 			err := Logging.InvalidIdentifierParserError{Name: id.Value, Line: p.lexer.Line()}
-			p.logger.Error(err.Error())
+			p.fail(&err)
 		} else {
-			w := AST.WhileNode{Id: AST.IdentToken{Name: id.Value}}
+			w := AST.WhileNode{Span: p.span(t), Id: ident(id)}
 			p.appendNode(&w)
 		}
 
 	case Lexer.T_END:
-		p.popBlock()
+		p.popBlock(t)
 
 	case Lexer.T_DONE:
 		if len(p.blockStack) != 1 {
 			err := Logging.InvalidEndParserError{Line: p.lexer.Line()}
-			p.logger.Error(err.Error())
+			p.fail(&err)
+		}
+		if t.Value != Lexer.EOF_VALUE {
+			pos := tokenPos(t)
+			p.Ast.Done = &pos
+		}
+		for _, c := range p.lexer.Comments {
+			p.Ast.Comments = append(p.Ast.Comments, AST.Comment{
+				Span: AST.Span{Start: AST.Pos{Line: c.Line, Col: c.Col}, End: AST.Pos{Line: c.EndLine, Col: c.EndCol}},
+				Text: c.Text,
+			})
+		}
+		for _, t := range p.lexer.Tokens {
+			p.Ast.Tokens = append(p.Ast.Tokens, tokenPos(t))
 		}
 		return false
 
@@ -222,137 +408,171 @@ func (p *Parser) parseNext() bool {
 
 		var rt AST.Token
 		if r.Type == Lexer.T_IDENT {
-			rt = &AST.IdentToken{Name: r.Value}
+			rt = &AST.IdentToken{Name: r.Value, Pos: tokenPos(r)}
 		} else if r.Type == Lexer.T_LIT {
-			rt = parseLit(r.Value)
+			rt = parseLit(r)
 		} else {
 			err := Logging.InvalidRightParserError{Line: p.lexer.Line()}
-			p.logger.Error(err.Error())
+			p.fail(&err)
 		}
 
 		switch op.Type {
 		case Lexer.T_ASSIGN:
 			p.appendNode(&AST.AssignNode{
-				Left:  AST.IdentToken{Name: t.Value},
+				Span:  p.span(t),
+				Left:  ident(t),
 				Right: rt,
 			})
 		case Lexer.T_ADD:
 			p.appendNode(&AST.AddNode{
-				Left:  AST.IdentToken{Name: t.Value},
+				Span:  p.span(t),
+				Left:  ident(t),
 				Right: rt,
 			})
 		case Lexer.T_SUB:
 			p.appendNode(&AST.SubNode{
-				Left:  AST.IdentToken{Name: t.Value},
+				Span:  p.span(t),
+				Left:  ident(t),
 				Right: rt,
 			})
 		default:
 			err := Logging.InvalidOperatorParserError{Line: p.lexer.Line()}
-			p.logger.Error(err.Error())
+			p.fail(&err)
 		}
 
 	case Lexer.T_WRITE:
 		r := p.lexer.Advance()
 		var rt AST.Token
 		if r.Type == Lexer.T_IDENT {
-			rt = &AST.IdentToken{Name: r.Value}
+			rt = &AST.IdentToken{Name: r.Value, Pos: tokenPos(r)}
 		} else if r.Type == Lexer.T_LIT {
-			rt = parseLit(r.Value)
+			rt = parseLit(r)
 		} else {
 			err := Logging.InvalidRightParserError{Line: p.lexer.Line()}
-			p.logger.Error(err.Error())
+			p.fail(&err)
 		}
-		p.appendNode(&AST.WriteNode{Value: rt})
+		p.appendNode(&AST.WriteNode{Span: p.span(t), Value: rt})
 
 	case Lexer.T_READ:
 		id := p.lexer.Advance()
 		if id.Type != Lexer.T_IDENT {
 			err := Logging.InvalidIdentifierParserError{Name: id.Value, Line: p.lexer.Line()}
-			p.logger.Error(err.Error())
+			p.fail(&err)
 		}
 		p.appendNode(&AST.ReadNode{
-			Value: AST.IdentToken{Name: id.Value},
+			Span:  p.span(t),
+			Value: ident(id),
 		})
 
 	case Lexer.T_FREE:
 		id := p.lexer.Advance()
 		if id.Type != Lexer.T_IDENT {
 			err := Logging.InvalidIdentifierParserError{Name: id.Value, Line: p.lexer.Line()}
-			p.logger.Error(err.Error())
+			p.fail(&err)
 		}
 		p.appendNode(&AST.FreeNode{
-			Value: AST.IdentToken{Name: id.Value},
+			Span:  p.span(t),
+			Value: ident(id),
 		})
 
 	case Lexer.T_BREAKPOINT:
-		p.appendNode(&AST.BreakpointNode{})
+		p.appendNode(&AST.BreakpointNode{Span: p.span(t)})
 
 	case Lexer.T_MACRO_BEGIN:
 		id := p.lexer.Advance()
 		if id.Type != Lexer.T_IDENT {
 			err := Logging.InvalidIdentifierParserError{Name: id.Value, Line: p.lexer.Line()}
-			p.logger.Error(err.Error())
+			p.fail(&err)
 		}
 		prms := p.lexer.Advance()
 		if prms.Type != Lexer.T_MACRO_SET_PARAMS {
 			err := Logging.InvalidIdentifierParserError{Line: p.lexer.Line()}
-			p.logger.Error(err.Error())
+			p.fail(&err)
 		}
-		m := AST.MacroNode{Name: AST.IdentToken{Name: id.Value}}
+		m := AST.MacroNode{Name: ident(id)}
 		for p.lexer.Peek().Type != Lexer.T_MACRO_DEFINE {
 			paramId := p.lexer.Advance()
 			if paramId.Type != Lexer.T_IDENT {
 				err := Logging.InvalidIdentifierParserError{Name: paramId.Value, Line: p.lexer.Line()}
-				p.logger.Error(err.Error())
+				p.fail(&err)
 			}
-			m.Params = append(m.Params, AST.IdentToken{Name: paramId.Value})
+			m.Params = append(m.Params, ident(paramId))
 		}
 		p.lexer.Advance()
+		m.Span = p.span(t)
 		p.appendNode(&m)
 		p.macros[m.Name.Name] = &m
 
-	case Lexer.T_MACRO_END:
-		if len(p.blockStack) < 2 {
-			err := Logging.InvalidEndParserError{Line: p.lexer.Line()}
-			p.logger.Error(err.Error())
+	case Lexer.T_ASM:
+		line := p.lexer.Line()
+		a := AST.AsmNode{}
+		for p.lexer.Peek().Type != Lexer.T_MACRO_DEFINE {
+			binding := p.lexer.Advance()
+			if binding.Type != Lexer.T_IDENT {
+				err := Logging.InvalidIdentifierParserError{Name: binding.Value, Line: p.lexer.Line()}
+				p.fail(&err)
+			}
+			a.Bindings = append(a.Bindings, ident(binding))
+		}
+		p.lexer.Advance()
+		if len(a.Bindings) == 0 {
+			err := Logging.InvalidIdentifierParserError{Name: "asm", Line: line}
+			p.fail(&err)
 		}
-		p.blockStack = p.blockStack[:len(p.blockStack)-1]
+		bodyLine, bodyCol := p.lexer.Position()
+		raw, ok := p.lexer.ReadRaw(Lexer.ASM_END_KEYWORD)
+		if !ok {
+			err := Logging.UnterminatedAsmParserError{Line: line}
+			p.fail(&err)
+		}
+		a.Parts = p.parseAsmBody(raw, AST.Pos{Line: bodyLine, Col: bodyCol}, a.Bindings)
+		a.Raw = raw
+		a.Span = p.span(t)
+		p.appendNode(&a)
+
+	case Lexer.T_ASM_END:
+		err := Logging.InvalidEndParserError{Line: p.lexer.Line()}
+		p.fail(&err)
+
+	case Lexer.T_MACRO_END:
+		p.popBlock(t)
 
 	case Lexer.T_MACRO_CALL:
 		id := p.lexer.Advance()
 		if id.Type != Lexer.T_IDENT {
 			err := Logging.InvalidIdentifierParserError{Name: id.Value, Line: p.lexer.Line()}
-			p.logger.Error(err.Error())
+			p.fail(&err)
 		}
 
 		mc := &AST.MacroCallNode{
-			Name: AST.IdentToken{Name: id.Value},
-			Args: make(map[AST.IdentToken]AST.Token),
+			Name: ident(id),
+			Args: make(map[string]AST.Token),
 		}
 		macro, found := p.macros[mc.Name.Name]
 		if !found {
 			err := Logging.InvalidIdentifierParserError{Name: mc.Name.Name, Line: p.lexer.Line()}
-			p.logger.Error(err.Error())
+			p.fail(&err)
 		}
 
 		for i := range macro.Params {
 			arg := p.lexer.Advance()
 			if arg.Type == Lexer.T_IDENT {
-				mc.Args[macro.Params[i]] = &AST.IdentToken{Name: arg.Value}
+				mc.Args[macro.Params[i].Name] = &AST.IdentToken{Name: arg.Value, Pos: tokenPos(arg)}
 			} else if arg.Type == Lexer.T_LIT {
-				mc.Args[macro.Params[i]] = parseLit(arg.Value)
+				mc.Args[macro.Params[i].Name] = parseLit(arg)
 			} else {
 				err := Logging.InvalidLiteralParserError{Line: p.lexer.Line()}
-				p.logger.Error(err.Error())
+				p.fail(&err)
 			}
 		}
 
+		mc.Span = p.span(t)
 		expandedBlock := p.copyNode(mc, nil).(*AST.BlockNode)
 		p.blockStack[len(p.blockStack)-1].Nodes = append(p.blockStack[len(p.blockStack)-1].Nodes, expandedBlock)
 
 	default:
 		err := Logging.InvalidIdentifierParserError{Name: t.Value, Line: p.lexer.Line()}
-		p.logger.Error(err.Error())
+		p.fail(&err)
 	}
 	return true
 }
@@ -362,3 +582,65 @@ func (p *Parser) Parse() AST.Ast {
 	}
 	return p.Ast
 }
+
+// ParseMore parses another piece of a program with a fresh lexer, keeping the
+// macros and defines of earlier pieces. The new statements are appended to Ast
+// and also returned on their own. A piece that fails to parse leaves no
+// macros behind.
+func (p *Parser) ParseMore(source string) *AST.BlockNode {
+	macros := maps.Clone(p.macros)
+	parsed := false
+	defer func() {
+		if !parsed {
+			p.macros = macros
+		}
+	}()
+
+	p.lexer = Lexer.NewLexer(source, p.logger)
+	for _, name := range p.defines {
+		p.lexer.Define(name)
+	}
+	block := &AST.BlockNode{}
+	p.blockStack = []*AST.BlockNode{block}
+	for p.parseNext() {
+	}
+	p.Ast.Root.Nodes = append(p.Ast.Root.Nodes, block.Nodes...)
+	parsed = true
+	return block
+}
+
+// Unfinished reports whether source stops inside an if, while, macro or asm
+// block, so that more input is needed before it can be parsed. Source with
+// lexing errors is not unfinished; parsing it reports the error.
+func Unfinished(source string) bool {
+	lexer := Lexer.NewLexer(source, Logging.NewRecoverableLogger("braining_parser"))
+
+	depth := 0
+	unfinished := false
+	Logging.Catch(func() {
+		for {
+			switch lexer.Advance().Type {
+			case Lexer.T_IF, Lexer.T_WHILE, Lexer.T_MACRO_BEGIN:
+				depth++
+			case Lexer.T_END, Lexer.T_MACRO_END:
+				depth--
+			case Lexer.T_ASM:
+				for lexer.Peek().Type == Lexer.T_IDENT {
+					lexer.Advance()
+				}
+				if t := lexer.Advance(); t.Type != Lexer.T_MACRO_DEFINE {
+					unfinished = t.Type == Lexer.T_DONE
+					return
+				}
+				if _, ok := lexer.ReadRaw(Lexer.ASM_END_KEYWORD); !ok {
+					unfinished = true
+					return
+				}
+			case Lexer.T_DONE:
+				unfinished = depth > 0
+				return
+			}
+		}
+	})
+	return unfinished
+}
@@ -0,0 +1,131 @@
+package Parser_test
+
+import (
+	"braining/AST"
+	"braining/Logging"
+	"braining/Parser"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// parse parses src with a recoverable logger and returns what it raised
+func parse(src string) error {
+	return Logging.Catch(func() {
+		Parser.NewParser(src, Logging.NewRecoverableLogger("test")).Parse()
+	})
+}
+
+// TestLiteralArgumentForVariable checks that a literal passed to a parameter
+// the macro uses as a variable is reported at the literal
+func TestLiteralArgumentForVariable(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		call   string
+		param  string
+		line   int
+		col    int
+		endCol int
+	}{
+		{"assign", "a = 1", "call m 5", "a", 5, 8, 9},
+		{"add", "a += 1", "call m 5", "a", 5, 8, 9},
+		{"sub", "a -= 1", "call m 'x'", "a", 5, 8, 11},
+		{"read", "read a", "call m 5", "a", 5, 8, 9},
+		{"free", "free a", "call m 5", "a", 5, 8, 9},
+		{"if", "if a\n  write 1\nend", "call m 5", "a", 7, 8, 9},
+		{"while not", "while not a\n  a = 1\nend", "call m 12", "a", 7, 8, 10},
+		{"asm binding", "asm a define\n+-\nendasm", "call m 5", "a", 7, 8, 9},
+		{"asm reference", "x = 1\nasm x a define\n{a}+-{x}\nendasm", "call m 5", "a", 8, 8, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "x = 1\nmacro m takes a define\n" + tt.body + "\nemcro\n" + tt.call + "\n"
+			err := parse(src)
+			var lit *Logging.LiteralArgumentParserError
+			if !errors.As(err, &lit) {
+				t.Fatalf("got %v, want a literal argument error", err)
+			}
+			if lit.Param != tt.param {
+				t.Errorf("param %q, want %q", lit.Param, tt.param)
+			}
+			var pos *Logging.SourceError
+			if !errors.As(err, &pos) {
+				t.Fatalf("%v has no position", err)
+			}
+			if pos.Line != tt.line || pos.Col != tt.col || pos.EndLine != tt.line || pos.EndCol != tt.endCol {
+				t.Errorf("at %d:%d-%d:%d, want %d:%d-%d:%d", pos.Line, pos.Col, pos.EndLine, pos.EndCol, tt.line, tt.col, tt.line, tt.endCol)
+			}
+		})
+	}
+}
+
+// TestLiteralArgument checks that literals are still accepted where the
+// parameter is only read
+func TestLiteralArgument(t *testing.T) {
+	tests := []string{
+		"macro m takes a define\nwrite a\nemcro\ncall m 5\n",
+		"macro m takes a define\nx = a\nx += a\nx -= a\nemcro\ncall m 'q'\n",
+		"macro m takes a define\nx = a\nemcro\nmacro n takes b define\ncall m b\nemcro\ncall n 7\n",
+	}
+	for _, src := range tests {
+		if err := parse(src); err != nil {
+			t.Errorf("%q: %v", src, err)
+		}
+	}
+}
+
+// TestNestedLiteralArgument checks that a literal passed through another
+// macro is reported where it was written
+func TestNestedLiteralArgument(t *testing.T) {
+	src := "macro m takes a define\na += 1\nemcro\nmacro n takes b define\ncall m b\nemcro\ncall n 7\n"
+	var pos *Logging.SourceError
+	if err := parse(src); !errors.As(err, &pos) {
+		t.Fatalf("got %v, want a positioned error", err)
+	}
+	if pos.Line != 7 || pos.Col != 8 {
+		t.Errorf("at %d:%d, want 7:8", pos.Line, pos.Col)
+	}
+}
+
+// TestAsmComments checks that endasm and references inside comments in an
+// asm body are left alone
+func TestAsmComments(t *testing.T) {
+	src := "x = 1\ny = 0\nasm x y define\n| endasm {y} |[-{y}+{x}]\nendasm\nendasmx = 1\n"
+	a := Parser.NewParser(src, nil).Parse()
+	if len(a.Root.Nodes) != 4 {
+		t.Fatalf("got %d statements, want 4", len(a.Root.Nodes))
+	}
+	asm, ok := a.Root.Nodes[2].(*AST.AsmNode)
+	if !ok {
+		t.Fatalf("got %T, want an asm block", a.Root.Nodes[2])
+	}
+	refs := []string{}
+	for _, part := range asm.Parts {
+		refs = append(refs, part.Ref.Name)
+	}
+	if want := []string{"", "y", "x"}; !slices.Equal(refs, want) {
+		t.Errorf("references %q, want %q", refs, want)
+	}
+}
+
+// TestParseMoreKeepsNoFailedMacros checks that a piece that fails to parse
+// does not leave the macros it defined before the error
+func TestParseMoreKeepsNoFailedMacros(t *testing.T) {
+	p := Parser.NewParser("", Logging.NewRecoverableLogger("test"))
+	pieces := []struct {
+		src string
+		ok  bool
+	}{
+		{"macro m takes a define\nwrite a\nemcro\n", true},
+		{"macro n takes a define\nwrite a\nemcro\nx = = 1\n", false},
+		{"call n 1\n", false},
+		{"call m 1\nmacro n takes a define\nwrite a\nemcro\ncall n 2\n", true},
+	}
+	for _, piece := range pieces {
+		err := Logging.Catch(func() { p.ParseMore(piece.src) })
+		if (err == nil) != piece.ok {
+			t.Errorf("%q: got %v", piece.src, err)
+		}
+	}
+}
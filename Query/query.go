@@ -0,0 +1,289 @@
+package Query
+
+import (
+	"braining/AST"
+	"braining/Lexer"
+	"braining/Logging"
+	"sort"
+)
+
+type SymbolKind int
+
+const (
+	S_VARIABLE SymbolKind = iota
+	S_MACRO
+	S_PARAM
+)
+
+// Symbol is what a name refers to. Variables are global, since macros expand
+// in place; a macro's parameters are only visible in its body.
+type Symbol struct {
+	Kind  SymbolKind
+	Name  string
+	Macro string // Macro a parameter belongs to
+}
+
+// Occurrence is one place a symbol is named in the source. Definitions are
+// macro headers, parameter lists, and the assignments and reads that create
+// variables.
+type Occurrence struct {
+	Symbol     Symbol
+	Pos        AST.Pos
+	Definition bool
+}
+
+// End is the position just past the name
+func (o Occurrence) End() AST.Pos {
+	return AST.Pos{Line: o.Pos.Line, Col: o.Pos.Col + len([]rune(o.Symbol.Name))}
+}
+
+// Index finds every name in a program as written. Macro expansions are not
+// visited; their calls and the macro bodies are.
+type Index struct {
+	Occurrences []Occurrence // In source order
+
+	bodies map[string][]Occurrence // Occurrences inside each macro's body
+}
+
+func NewIndex(a *AST.Ast) *Index {
+	ix := &Index{bodies: make(map[string][]Occurrence)}
+	ix.block(&a.Root, nil)
+	sort.SliceStable(ix.Occurrences, func(i, j int) bool {
+		return before(ix.Occurrences[i].Pos, ix.Occurrences[j].Pos)
+	})
+	return ix
+}
+
+func before(a, b AST.Pos) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Col < b.Col
+}
+
+// scope is the macro whose body is being indexed, if any
+type scope struct {
+	macro  string
+	params map[string]bool
+}
+
+func (ix *Index) add(o Occurrence, s *scope) {
+	ix.Occurrences = append(ix.Occurrences, o)
+	if s != nil {
+		ix.bodies[s.macro] = append(ix.bodies[s.macro], o)
+	}
+}
+
+// ident records a use of a variable or, inside a macro, of a parameter
+func (ix *Index) ident(id AST.IdentToken, s *scope, definition bool) {
+	sym := Symbol{Kind: S_VARIABLE, Name: id.Name}
+	if s != nil && s.params[id.Name] {
+		sym = Symbol{Kind: S_PARAM, Name: id.Name, Macro: s.macro}
+	}
+	ix.add(Occurrence{Symbol: sym, Pos: id.Pos, Definition: definition}, s)
+}
+
+func (ix *Index) token(t AST.Token, s *scope) {
+	if t != nil && t.Type() == AST.T_IDENT {
+		ix.ident(*t.(*AST.IdentToken), s, false)
+	}
+}
+
+func (ix *Index) block(b *AST.BlockNode, s *scope) {
+	for _, n := range b.Nodes {
+		ix.node(n, s)
+	}
+}
+
+func (ix *Index) node(n AST.Node, s *scope) {
+	switch n.Type() {
+	case AST.N_BLOCK:
+		b := n.(*AST.BlockNode)
+		if b.Call == nil {
+			ix.block(b, s)
+			return
+		}
+		ix.add(Occurrence{Symbol: Symbol{Kind: S_MACRO, Name: b.Call.Name.Name}, Pos: b.Call.Name.Pos}, s)
+		for _, arg := range b.Call.Args {
+			ix.token(arg, s)
+		}
+	case AST.N_ASSIGN:
+		a := n.(*AST.AssignNode)
+		ix.ident(a.Left, s, true)
+		ix.token(a.Right, s)
+	case AST.N_ADD:
+		a := n.(*AST.AddNode)
+		ix.ident(a.Left, s, false)
+		ix.token(a.Right, s)
+	case AST.N_SUB:
+		sub := n.(*AST.SubNode)
+		ix.ident(sub.Left, s, false)
+		ix.token(sub.Right, s)
+	case AST.N_IF:
+		i := n.(*AST.IfNode)
+		ix.ident(i.Id, s, false)
+		ix.block(&i.Block, s)
+	case AST.N_IFNOT:
+		i := n.(*AST.IfNotNode)
+		ix.ident(i.Id, s, false)
+		ix.block(&i.Block, s)
+	case AST.N_WHILE:
+		w := n.(*AST.WhileNode)
+		ix.ident(w.Id, s, false)
+		ix.block(&w.Block, s)
+	case AST.N_WHILENOT:
+		w := n.(*AST.WhileNotNode)
+		ix.ident(w.Id, s, false)
+		ix.block(&w.Block, s)
+	case AST.N_WRITE:
+		ix.token(n.(*AST.WriteNode).Value, s)
+	case AST.N_READ:
+		ix.ident(n.(*AST.ReadNode).Value, s, true)
+	case AST.N_FREE:
+		ix.ident(n.(*AST.FreeNode).Value, s, false)
+	case AST.N_ASM:
+		a := n.(*AST.AsmNode)
+		for _, b := range a.Bindings {
+			ix.ident(b, s, false)
+		}
+		// References inside the body name bindings, which are what the
+		// binding list names
+		for _, part := range a.Parts {
+			if part.Ref.Name != "" {
+				ix.ident(part.Ref, s, false)
+			}
+		}
+	case AST.N_MACRO:
+		m := n.(*AST.MacroNode)
+		ix.add(Occurrence{Symbol: Symbol{Kind: S_MACRO, Name: m.Name.Name}, Pos: m.Name.Pos, Definition: true}, s)
+		inner := &scope{macro: m.Name.Name, params: make(map[string]bool)}
+		for _, p := range m.Params {
+			inner.params[p.Name] = true
+			ix.add(Occurrence{Symbol: Symbol{Kind: S_PARAM, Name: p.Name, Macro: m.Name.Name}, Pos: p.Pos, Definition: true}, nil)
+		}
+		ix.block(&m.Block, inner)
+	}
+}
+
+// At returns the occurrence at pos, counting the position just past a name as
+// part of it
+func (ix *Index) At(pos AST.Pos) (Occurrence, bool) {
+	for _, o := range ix.Occurrences {
+		if o.Pos.Line == pos.Line && o.Pos.Col <= pos.Col && pos.Col <= o.End().Col {
+			return o, true
+		}
+	}
+	return Occurrence{}, false
+}
+
+// Definition returns where the symbol at pos is defined. A variable can be set
+// up more than once, so this is the last definition before pos, or the first
+// one if it is used before any.
+func (ix *Index) Definition(pos AST.Pos) (Occurrence, bool) {
+	at, ok := ix.At(pos)
+	if !ok {
+		return Occurrence{}, false
+	}
+	var first, last *Occurrence
+	for i, o := range ix.Occurrences {
+		if o.Symbol != at.Symbol || !o.Definition {
+			continue
+		}
+		if first == nil {
+			first = &ix.Occurrences[i]
+		}
+		if !before(at.Pos, o.Pos) {
+			last = &ix.Occurrences[i]
+		}
+	}
+	switch {
+	case last != nil:
+		return *last, true
+	case first != nil:
+		return *first, true
+	}
+	return Occurrence{}, false
+}
+
+// References returns every occurrence of the symbol at pos, leaving out its
+// definitions unless includeDefinitions is set
+func (ix *Index) References(pos AST.Pos, includeDefinitions bool) []Occurrence {
+	at, ok := ix.At(pos)
+	if !ok {
+		return nil
+	}
+	res := []Occurrence{}
+	for _, o := range ix.Occurrences {
+		if o.Symbol == at.Symbol && (includeDefinitions || !o.Definition) {
+			res = append(res, o)
+		}
+	}
+	return res
+}
+
+// Rename returns the occurrences to rewrite to give the symbol at pos a new
+// name. It fails if the name is not an identifier, or if the new name would
+// change what any occurrence refers to.
+func (ix *Index) Rename(pos AST.Pos, name string) ([]Occurrence, error) {
+	at, ok := ix.At(pos)
+	if !ok {
+		return nil, nil
+	}
+	if !isIdentifier(name) {
+		return nil, &Logging.InvalidRenameQueryError{Name: name, Reason: "not an identifier"}
+	}
+	if name == at.Symbol.Name {
+		return ix.References(pos, true), nil
+	}
+
+	renamed := at.Symbol
+	renamed.Name = name
+	for _, o := range ix.Occurrences {
+		if o.Symbol == renamed {
+			return nil, &Logging.InvalidRenameQueryError{Name: name, Reason: "already in use"}
+		}
+	}
+
+	// Parameters and global variables share names inside macro bodies, so a
+	// rename must not let one capture the other
+	switch at.Symbol.Kind {
+	case S_VARIABLE:
+		for macro, body := range ix.bodies {
+			if ix.isParam(macro, name) && uses(body, at.Symbol) {
+				return nil, &Logging.InvalidRenameQueryError{Name: name, Reason: "would be captured by a parameter of macro " + macro}
+			}
+		}
+	case S_PARAM:
+		if uses(ix.bodies[at.Symbol.Macro], Symbol{Kind: S_VARIABLE, Name: name}) {
+			return nil, &Logging.InvalidRenameQueryError{Name: name, Reason: "macro " + at.Symbol.Macro + " uses a variable of that name"}
+		}
+	}
+	return ix.References(pos, true), nil
+}
+
+func (ix *Index) isParam(macro, name string) bool {
+	for _, o := range ix.Occurrences {
+		if o.Symbol == (Symbol{Kind: S_PARAM, Name: name, Macro: macro}) {
+			return true
+		}
+	}
+	return false
+}
+
+func uses(occurrences []Occurrence, sym Symbol) bool {
+	for _, o := range occurrences {
+		if o.Symbol == sym {
+			return true
+		}
+	}
+	return false
+}
+
+// isIdentifier reports whether name lexes as a single identifier, which rules
+// out keywords
+func isIdentifier(name string) bool {
+	ok := false
+	Logging.Catch(func() {
+		l := Lexer.NewLexer(name, Logging.NewRecoverableLogger("braining_query"))
+		t := l.Advance()
+		ok = t.Type == Lexer.T_IDENT && t.Value == name && l.Advance().Type == Lexer.T_DONE
+	})
+	return ok
+}
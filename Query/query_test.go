@@ -0,0 +1,108 @@
+package Query_test
+
+import (
+	"braining/AST"
+	"braining/Logging"
+	"braining/Parser"
+	"braining/Query"
+	"errors"
+	"slices"
+	"testing"
+)
+
+const SOURCE = `x = 1
+macro m takes a define
+a += x
+emcro
+call m x
+y = 2
+call m y
+read y
+`
+
+func index(t *testing.T) *Query.Index {
+	t.Helper()
+	var a AST.Ast
+	if err := Logging.Catch(func() {
+		a = Parser.NewParser(SOURCE, Logging.NewRecoverableLogger("test")).Parse()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return Query.NewIndex(&a)
+}
+
+func positions(occurrences []Query.Occurrence) []AST.Pos {
+	res := []AST.Pos{}
+	for _, o := range occurrences {
+		res = append(res, o.Pos)
+	}
+	return res
+}
+
+func TestDefinition(t *testing.T) {
+	tests := []struct {
+		at   AST.Pos
+		want AST.Pos
+	}{
+		{AST.Pos{Line: 5, Col: 8}, AST.Pos{Line: 1, Col: 1}},  // x at a call
+		{AST.Pos{Line: 3, Col: 6}, AST.Pos{Line: 1, Col: 1}},  // x in the macro body
+		{AST.Pos{Line: 3, Col: 1}, AST.Pos{Line: 2, Col: 15}}, // A parameter
+		{AST.Pos{Line: 7, Col: 6}, AST.Pos{Line: 2, Col: 7}},  // A macro
+		{AST.Pos{Line: 7, Col: 9}, AST.Pos{Line: 6, Col: 1}},  // The end of a name
+		{AST.Pos{Line: 8, Col: 6}, AST.Pos{Line: 8, Col: 6}},  // The latest definition
+	}
+	ix := index(t)
+	for _, tt := range tests {
+		def, ok := ix.Definition(tt.at)
+		if !ok || def.Pos != tt.want {
+			t.Errorf("at %v: got %v (%v), want %v", tt.at, def.Pos, ok, tt.want)
+		}
+	}
+	if _, ok := ix.Definition(AST.Pos{Line: 5, Col: 3}); ok {
+		t.Error("found a definition for a keyword")
+	}
+}
+
+func TestReferences(t *testing.T) {
+	ix := index(t)
+	got := positions(ix.References(AST.Pos{Line: 1, Col: 1}, true))
+	want := []AST.Pos{{Line: 1, Col: 1}, {Line: 3, Col: 6}, {Line: 5, Col: 8}}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	got = positions(ix.References(AST.Pos{Line: 2, Col: 7}, false))
+	want = []AST.Pos{{Line: 5, Col: 6}, {Line: 7, Col: 6}}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestRename(t *testing.T) {
+	tests := []struct {
+		name    string
+		at      AST.Pos
+		to      string
+		want    []AST.Pos
+		refused bool
+	}{
+		{"variable", AST.Pos{Line: 6, Col: 1}, "z", []AST.Pos{{Line: 6, Col: 1}, {Line: 7, Col: 8}, {Line: 8, Col: 6}}, false},
+		{"parameter", AST.Pos{Line: 3, Col: 1}, "b", []AST.Pos{{Line: 2, Col: 15}, {Line: 3, Col: 1}}, false},
+		{"macro", AST.Pos{Line: 5, Col: 6}, "n", []AST.Pos{{Line: 2, Col: 7}, {Line: 5, Col: 6}, {Line: 7, Col: 6}}, false},
+		{"same name", AST.Pos{Line: 6, Col: 1}, "y", []AST.Pos{{Line: 6, Col: 1}, {Line: 7, Col: 8}, {Line: 8, Col: 6}}, false},
+		{"in use", AST.Pos{Line: 6, Col: 1}, "x", nil, true},
+		{"keyword", AST.Pos{Line: 6, Col: 1}, "while", nil, true},
+		{"not a name", AST.Pos{Line: 6, Col: 1}, "1y", nil, true},
+		{"variable captured by a parameter", AST.Pos{Line: 1, Col: 1}, "a", nil, true},
+		{"parameter captures a variable", AST.Pos{Line: 2, Col: 15}, "x", nil, true},
+	}
+	ix := index(t)
+	for _, tt := range tests {
+		got, err := ix.Rename(tt.at, tt.to)
+		var invalid *Logging.InvalidRenameQueryError
+		if tt.refused != errors.As(err, &invalid) {
+			t.Errorf("%s: got %v", tt.name, err)
+		} else if !tt.refused && !slices.Equal(positions(got), tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, positions(got), tt.want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package Repl
+
+import (
+	"braining/AST"
+	"braining/Compiler"
+	"braining/IR"
+	"braining/Interpreter"
+	"braining/Logging"
+	"braining/Parser"
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	PROMPT      = "br> "
+	MORE_PROMPT = "... " // Shown while a block is still open
+)
+
+// Repl evaluates braining a statement at a time. Macros, variables and the
+// tape all persist, so each entry can use what the earlier ones set up.
+type Repl struct {
+	parser   *Parser.Parser
+	session  *Compiler.Session
+	machine  *Interpreter.Machine
+	in       *bufio.Reader
+	out      io.Writer
+	lastAst  AST.Ast
+	lastCode string
+}
+
+// NewRepl reads statements and commands from in. Programs read their input
+// from in as well, and write to out.
+func NewRepl(target *IR.Target, optLevel int, in io.Reader, out io.Writer) (*Repl, error) {
+	// Errors are reported by the REPL itself instead of ending the process
+	logger := Logging.NewRecoverableLogger("braining_repl")
+
+	var c *Compiler.Compiler
+	if err := Logging.Catch(func() {
+		c = Compiler.NewCompiler(AST.Ast{}, target, logger)
+	}); err != nil {
+		return nil, err
+	}
+	c.OptLevel = optLevel
+
+	config := Interpreter.DefaultConfig()
+	config.CellBits = c.Target().CellBits
+	config.Wrapping = c.Target().Wrapping
+	config.Signed = c.Target().AllowNegative
+
+	r := &Repl{
+		parser:  Parser.NewParser("", logger),
+		session: c.NewSession(),
+		in:      bufio.NewReader(in),
+		out:     out,
+	}
+	r.machine = Interpreter.NewMachine(&Interpreter.Program{}, config, r.in, out)
+	return r, nil
+}
+
+// Define enables regions guarded by #if name in everything entered afterwards
+func (r *Repl) Define(name string) {
+	r.parser.Define(name)
+}
+
+// Eval parses, compiles and runs a piece of source
+func (r *Repl) Eval(source string) error {
+	var block *AST.BlockNode
+	if err := Logging.Catch(func() {
+		block = r.parser.ParseMore(source)
+	}); err != nil {
+		return err
+	}
+
+	code, err := r.session.Compile(block)
+	if err != nil {
+		return err
+	}
+	r.lastAst = AST.Ast{Root: *block}
+	r.lastCode = code
+
+	program, err := Interpreter.Compile(code)
+	if err != nil {
+		return err
+	}
+	r.machine.Load(program)
+	return r.machine.Run()
+}
+
+// Vars prints every variable with its cell and value
+func (r *Repl) Vars() {
+	vars := r.session.Variables()
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cell := vars[name]
+		v := r.machine.Value(cell)
+		fmt.Fprintf(r.out, "%s = %d", name, v)
+		if v >= 32 && v < 127 {
+			fmt.Fprintf(r.out, " '%c'", rune(v))
+		}
+		fmt.Fprintf(r.out, " (@%d)\n", cell)
+	}
+}
+
+const HELP = `Enter braining statements to run them. Blocks continue over several lines
+until they are closed; an empty line gives up on an unfinished block.
+Commands:
+  :vars    show every variable with its cell and value
+  :ast     show the AST of the last statement
+  :bf      show the Brainf*** generated for the last statement
+  :help    show this help
+  :quit    leave the REPL`
+
+// Run reads and evaluates until :quit or the end of input
+func (r *Repl) Run() error {
+	source := ""
+	for {
+		if source == "" {
+			fmt.Fprint(r.out, PROMPT)
+		} else {
+			fmt.Fprint(r.out, MORE_PROMPT)
+		}
+		line, err := r.in.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(r.out)
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		trimmed := strings.TrimSpace(line)
+
+		if source == "" {
+			switch trimmed {
+			case "":
+				continue
+			case ":vars":
+				r.Vars()
+				continue
+			case ":ast":
+				r.lastAst.Display()
+				continue
+			case ":bf":
+				fmt.Fprintln(r.out, r.lastCode)
+				continue
+			case ":help":
+				fmt.Fprintln(r.out, HELP)
+				continue
+			case ":quit", ":q":
+				return nil
+			}
+			if strings.HasPrefix(trimmed, ":") {
+				fmt.Fprintln(r.out, "unknown command "+trimmed+", try :help")
+				continue
+			}
+		}
+
+		source += line
+		if trimmed != "" && Parser.Unfinished(source) {
+			continue
+		}
+		if err := r.Eval(source); err != nil {
+			fmt.Fprintln(r.out, "error: "+err.Error())
+		}
+		source = ""
+	}
+}
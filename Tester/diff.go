@@ -0,0 +1,78 @@
+package Tester
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const NO_NEWLINE = "\\ No newline at end of output"
+
+// Diff compares expected and actual output line by line. Lines only in
+// expected start with "- ", lines only in actual with "+ ", and shared lines
+// with two spaces. Lines that are not printable text are quoted.
+func Diff(expected, actual []byte) string {
+	a, b := splitLines(string(expected)), splitLines(string(actual))
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	sb := &strings.Builder{}
+	line := func(prefix, text string) {
+		sb.WriteString(prefix + display(strings.TrimSuffix(text, "\n")) + "\n")
+		if prefix != "  " && !strings.HasSuffix(text, "\n") {
+			sb.WriteString(NO_NEWLINE + "\n")
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			line("  ", a[i])
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			line("- ", a[i])
+			i++
+		default:
+			line("+ ", b[j])
+			j++
+		}
+	}
+	return sb.String()
+}
+
+// splitLines keeps the newline on each line, so a missing final newline is a
+// difference
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func display(text string) string {
+	if !utf8.ValidString(text) {
+		return strconv.Quote(text)
+	}
+	for _, r := range text {
+		if !unicode.IsPrint(r) {
+			return strconv.Quote(text)
+		}
+	}
+	return text
+}
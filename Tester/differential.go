@@ -0,0 +1,169 @@
+package Tester
+
+import (
+	"braining/Evaluator"
+	"braining/Interpreter"
+	"braining/Logging"
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Comparison is the outcome of running a program both on the evaluator, which
+// defines what it means, and compiled on the interpreter
+type Comparison struct {
+	Name     string
+	Source   string
+	Input    []byte
+	Expected []byte // Output of the evaluator
+	Output   []byte // Output of the compiled program
+	EvalErr  error
+	RunErr   error
+	Skipped  error // Why the program could not be compared, if it could not
+}
+
+// Diverged reports whether the compiled program did something other than
+// what the evaluator did. When either side runs out of steps only the output
+// produced by both is compared, and when both take a cell out of its range
+// only the output produced before that.
+func (c Comparison) Diverged() bool {
+	if c.Skipped != nil {
+		return false
+	}
+	if c.Overflowed() {
+		return !bytes.Equal(c.Expected, c.Output)
+	}
+	if c.Inconclusive() {
+		n := min(len(c.Expected), len(c.Output))
+		return !bytes.Equal(c.Expected[:n], c.Output[:n])
+	}
+	return c.EvalErr != nil || c.RunErr != nil || !bytes.Equal(c.Expected, c.Output)
+}
+
+// Inconclusive reports whether either side ran out of steps. Compiled code can
+// take far more steps than the evaluator, since copying a value loops over it.
+func (c Comparison) Inconclusive() bool {
+	var evalLimit *Logging.StepLimitEvaluatorError
+	var runLimit *Logging.StepLimitInterpreterError
+	return errors.As(c.EvalErr, &evalLimit) || errors.As(c.RunErr, &runLimit)
+}
+
+// Overflowed reports whether both sides stopped at a value too large for a
+// cell, which means nothing more on a target whose cells do not wrap
+func (c Comparison) Overflowed() bool {
+	var evalOverflow *Logging.OverflowEvaluatorError
+	var runOverflow *Logging.CellRangeInterpreterError
+	return errors.As(c.EvalErr, &evalOverflow) && errors.As(c.RunErr, &runOverflow)
+}
+
+// Compare runs src on input both ways. Programs that do not compile, or that
+// the evaluator has no meaning for, are skipped.
+func Compare(name, src string, input []byte, opts *Options) Comparison {
+	if opts == nil {
+		opts = &Options{}
+	}
+	res := Comparison{Name: name, Source: src, Input: input}
+	a, program, err := compileSource(src, opts)
+	if err != nil {
+		res.Skipped = err
+		return res
+	}
+
+	config := interpreterConfig(opts)
+	expected := &bytes.Buffer{}
+	e := Evaluator.NewEvaluator(opts.Target, bytes.NewReader(input), expected)
+	e.EOF = config.EOF
+	e.MaxSteps = config.MaxSteps
+	res.EvalErr = e.Run(&a)
+	res.Expected = expected.Bytes()
+	var unsupported *Logging.UnsupportedEvaluatorError
+	if errors.As(res.EvalErr, &unsupported) {
+		res.Skipped = res.EvalErr
+		return res
+	}
+
+	output := &bytes.Buffer{}
+	res.RunErr = Interpreter.NewMachine(program, config, bytes.NewReader(input), output).Run()
+	res.Output = output.Bytes()
+	return res
+}
+
+// CompareRandom generates and compares n programs, numbered from first.
+// Program i comes from seed and i alone, so any of them can be generated again.
+func CompareRandom(seed uint64, first, n int, opts *Options) []Comparison {
+	if opts == nil {
+		opts = &Options{}
+	}
+	res := make([]Comparison, n)
+	parallel(jobs(opts), n, func(i int) {
+		src, input := Generate(rand.New(rand.NewPCG(seed, uint64(first+i))), opts.Target)
+		res[i] = Compare(fmt.Sprintf("seed %d program %d", seed, first+i), src, input, opts)
+	})
+	return res
+}
+
+// CompareCases compares the programs of cases, such as those found by
+// Discover, on their inputs
+func CompareCases(cases []Case, opts *Options) []Comparison {
+	if opts == nil {
+		opts = &Options{}
+	}
+	res := make([]Comparison, len(cases))
+	parallel(jobs(opts), len(cases), func(i int) {
+		src, err := os.ReadFile(cases[i].Program)
+		if err != nil {
+			res[i] = Comparison{Name: cases[i].String(), Skipped: err}
+			return
+		}
+		res[i] = Compare(cases[i].String(), string(src), cases[i].Input, opts)
+	})
+	return res
+}
+
+// Shrink makes a diverging program smaller by deleting statements, and whole
+// ifs, loops and macros, for as long as it keeps diverging. Deletions that
+// stop the program finishing are not kept.
+func Shrink(c Comparison, opts *Options) Comparison {
+	lines := strings.Split(strings.TrimSuffix(c.Source, "\n"), "\n")
+	for shrunk := true; shrunk; {
+		shrunk = false
+		for i := 0; i < len(lines); i++ {
+			for _, end := range []int{blockEnd(lines, i), i + 1} {
+				candidate := slices.Concat(lines[:i], lines[end:])
+				next := Compare(c.Name, strings.Join(candidate, "\n")+"\n", c.Input, opts)
+				if next.Diverged() && !next.Inconclusive() {
+					c, lines, shrunk = next, candidate, true
+					i--
+					break
+				}
+			}
+		}
+	}
+	return c
+}
+
+// blockEnd returns the index just past the statement starting at line i,
+// including its body and closing keyword when it opens a block
+func blockEnd(lines []string, i int) int {
+	depth := 0
+	for j := i; j < len(lines); j++ {
+		fields := strings.Fields(lines[j])
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "if", "while", "macro":
+			depth++
+		case "end", "emcro":
+			depth--
+		}
+		if depth <= 0 {
+			return j + 1
+		}
+	}
+	return len(lines)
+}
@@ -0,0 +1,59 @@
+package Tester_test
+
+import (
+	"braining/IR"
+	"braining/Logging"
+	"braining/Tester"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  *IR.Target
+		src     string
+		input   string
+		skipped bool
+	}{
+		{"arithmetic", nil, "x = 40\nx += 2\ny = x\ny -= 50\nwrite x\nwrite y\n", "", false},
+		{"input", nil, "x = 0\nread x\nwhile x\n  write x\n  read x\nend\n", "abc\x00", false},
+		{"overflow", &IR.Target{CellBits: 8}, "x = 200\nwrite x\nx += x\nwrite x\n", "", false},
+		{"asm", nil, "x = 1\nasm x define\n+\nendasm\n", "", true},
+		{"not a program", nil, "write q\n", "", true},
+	}
+	for _, tt := range tests {
+		c := Tester.Compare(tt.name, tt.src, []byte(tt.input), &Tester.Options{Target: tt.target})
+		if (c.Skipped != nil) != tt.skipped {
+			t.Errorf("%s: skipped %v", tt.name, c.Skipped)
+		}
+		if c.Diverged() || c.Inconclusive() {
+			t.Errorf("%s: evaluator %q (%v), compiled %q (%v)", tt.name, c.Expected, c.EvalErr, c.Output, c.RunErr)
+		}
+	}
+}
+
+func TestDiverged(t *testing.T) {
+	stepLimit := &Logging.StepLimitInterpreterError{Limit: 10}
+	evalStepLimit := &Logging.StepLimitEvaluatorError{Limit: 10}
+	overflow := &Logging.CellRangeInterpreterError{Value: 256}
+	evalOverflow := &Logging.OverflowEvaluatorError{Name: "x"}
+	tests := []struct {
+		name string
+		c    Tester.Comparison
+		want bool
+	}{
+		{"same output", Tester.Comparison{Expected: []byte("ab"), Output: []byte("ab")}, false},
+		{"other output", Tester.Comparison{Expected: []byte("ab"), Output: []byte("ac")}, true},
+		{"compiled failed", Tester.Comparison{Expected: []byte("ab"), Output: []byte("ab"), RunErr: overflow}, true},
+		{"out of steps", Tester.Comparison{Expected: []byte("abc"), Output: []byte("ab"), RunErr: stepLimit}, false},
+		{"out of steps apart", Tester.Comparison{Expected: []byte("abc"), Output: []byte("b"), EvalErr: evalStepLimit}, true},
+		{"both overflowed", Tester.Comparison{Expected: []byte("a"), Output: []byte("a"), EvalErr: evalOverflow, RunErr: overflow}, false},
+		{"overflowed apart", Tester.Comparison{Expected: []byte("a"), Output: []byte("ab"), EvalErr: evalOverflow, RunErr: overflow}, true},
+		{"skipped", Tester.Comparison{Expected: []byte("a"), Skipped: evalOverflow}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.c.Diverged(); got != tt.want {
+			t.Errorf("%s: diverged %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
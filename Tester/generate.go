@@ -0,0 +1,210 @@
+package Tester
+
+import (
+	"braining/IR"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+)
+
+const (
+	GEN_STATEMENTS = 30 // Statements at the top level of a generated program
+	GEN_DEPTH      = 3  // Deepest nesting of ifs and loops
+	GEN_INPUT      = 8  // Most bytes of input generated for a program
+)
+
+// generator writes random programs that the compiler accepts and that always
+// finish: every loop counts down a counter that nothing else in it writes
+type generator struct {
+	rng     *rand.Rand
+	target  *IR.Target
+	sb      strings.Builder
+	indent  int
+	vars    []string // Variables set so far, in program order
+	locked  []string // Counters of the loops being generated
+	macros  map[string]int
+	order   []string // Macro names in definition order
+	counter int      // For fresh names
+}
+
+// Generate returns a random program for target, and input for it
+func Generate(rng *rand.Rand, target *IR.Target) (string, []byte) {
+	if target == nil {
+		target = IR.DefaultTarget()
+	}
+	g := &generator{rng: rng, target: target, macros: make(map[string]int)}
+	for range rng.IntN(3) {
+		g.macro()
+	}
+	for range 1 + rng.IntN(3) {
+		g.line("%s = %s", g.fresh("v"), g.literal())
+	}
+	for range GEN_STATEMENTS {
+		g.statement(0)
+	}
+
+	input := make([]byte, rng.IntN(GEN_INPUT+1))
+	for i := range input {
+		input[i] = byte(rng.IntN(256))
+	}
+	return g.sb.String(), input
+}
+
+func (g *generator) line(format string, args ...any) {
+	g.sb.WriteString(strings.Repeat("  ", g.indent) + fmt.Sprintf(format, args...) + "\n")
+}
+
+func (g *generator) fresh(prefix string) string {
+	name := fmt.Sprintf("%s%d", prefix, g.counter)
+	g.counter++
+	g.vars = append(g.vars, name)
+	return name
+}
+
+// literal favours small values, which loops and tests care about
+func (g *generator) literal() string {
+	limit := min(g.target.MaxLiteral(), 255)
+	if g.rng.IntN(2) == 0 {
+		limit = min(limit, 9)
+	}
+	return fmt.Sprint(g.rng.IntN(limit + 1))
+}
+
+func (g *generator) pick(names []string) string {
+	return names[g.rng.IntN(len(names))]
+}
+
+// writable lists the variables a statement may change
+func (g *generator) writable() []string {
+	res := []string{}
+	for _, v := range g.vars {
+		if !slices.Contains(g.locked, v) {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func (g *generator) operand(names []string) string {
+	if g.rng.IntN(2) == 0 {
+		return g.literal()
+	}
+	return g.pick(names)
+}
+
+func (g *generator) statement(depth int) {
+	writable := g.writable()
+	if len(writable) == 0 {
+		g.line("%s = %s", g.fresh("v"), g.literal())
+		return
+	}
+
+	switch n := g.rng.IntN(20); {
+	case n < 3:
+		if g.rng.IntN(4) == 0 {
+			right := g.operand(g.vars)
+			g.line("%s = %s", g.fresh("v"), right)
+		} else {
+			g.line("%s = %s", g.pick(writable), g.operand(g.vars))
+		}
+	case n < 6:
+		g.line("%s += %s", g.pick(writable), g.operand(g.vars))
+	case n < 9:
+		g.line("%s -= %s", g.pick(writable), g.operand(g.vars))
+	case n < 12:
+		g.line("write %s", g.operand(g.vars))
+	case n < 13:
+		g.line("read %s", g.pick(writable))
+	case n < 14:
+		if depth > 0 || len(writable) < 2 {
+			g.statement(depth)
+			return
+		}
+		name := g.pick(writable)
+		g.line("free %s", name)
+		g.vars = slices.DeleteFunc(g.vars, func(v string) bool { return v == name })
+	case n < 15:
+		if len(g.order) == 0 {
+			g.statement(depth)
+			return
+		}
+		name := g.pick(g.order)
+		args := make([]string, g.macros[name])
+		for i := range args {
+			args[i] = g.pick(writable)
+		}
+		g.line("call %s %s", name, strings.Join(args, " "))
+	case depth >= GEN_DEPTH:
+		g.line("write %s", g.pick(g.vars))
+	case n < 17:
+		keyword := "if"
+		if g.rng.IntN(2) == 0 {
+			keyword = "if not"
+		}
+		g.line("%s %s", keyword, g.pick(g.vars))
+		g.body(depth)
+		g.line("end")
+	case n < 19:
+		// Counts down from 0 to 4
+		c := g.fresh("c")
+		g.line("%s = %d", c, g.rng.IntN(5))
+		g.line("while %s", c)
+		g.locked = append(g.locked, c)
+		g.body(depth)
+		g.line("  %s -= 1", c)
+		g.locked = g.locked[:len(g.locked)-1]
+		g.line("end")
+	default:
+		// Runs once for each of 1 to 3, until the counter reaches zero
+		c, done := g.fresh("c"), g.fresh("f")
+		g.line("%s = %d", c, 1+g.rng.IntN(3))
+		g.line("%s = 0", done)
+		g.line("while not %s", done)
+		g.locked = append(g.locked, c, done)
+		g.body(depth)
+		g.line("  %s -= 1", c)
+		g.line("  if not %s", c)
+		g.line("    %s = 1", done)
+		g.line("  end")
+		g.locked = g.locked[:len(g.locked)-2]
+		g.line("end")
+	}
+}
+
+func (g *generator) body(depth int) {
+	g.indent++
+	for range 1 + g.rng.IntN(4) {
+		g.statement(depth + 1)
+	}
+	g.indent--
+}
+
+// macro defines a macro that only works on its parameters
+func (g *generator) macro() {
+	name := fmt.Sprintf("m%d", len(g.order))
+	params := make([]string, 1+g.rng.IntN(3))
+	for i := range params {
+		params[i] = fmt.Sprintf("p%d", i)
+	}
+	g.line("macro %s takes %s define", name, strings.Join(params, " "))
+
+	g.indent++
+	for range 1 + g.rng.IntN(4) {
+		switch g.rng.IntN(4) {
+		case 0:
+			g.line("%s += %s", g.pick(params), g.operand(params))
+		case 1:
+			g.line("%s -= %s", g.pick(params), g.operand(params))
+		case 2:
+			g.line("%s = %s", g.pick(params), g.operand(params))
+		default:
+			g.line("write %s", g.pick(params))
+		}
+	}
+	g.indent--
+	g.line("emcro")
+
+	g.macros[name] = len(params)
+	g.order = append(g.order, name)
+}
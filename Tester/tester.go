@@ -0,0 +1,333 @@
+package Tester
+
+import (
+	"braining/AST"
+	"braining/Compiler"
+	"braining/IR"
+	"braining/Interpreter"
+	"braining/Logging"
+	"braining/Parser"
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PROGRAM_EXT  = ".br"
+	TEST_SUFFIX  = "_test" + PROGRAM_EXT
+	INPUT_EXT    = ".in"
+	OUTPUT_EXT   = ".out"
+	MAX_STEPS    = 100_000_000
+	DEFAULT_CASE = "default"
+)
+
+// Case is one run of a program. Programs named *_test.br with no case files
+// get a single case that only has to run cleanly. Case files sit next to the
+// program: name.in and name.out for a single case, or name.CASE.in and
+// name.CASE.out for as many named cases as needed. Either file may be left out,
+// for empty input or for output that is not checked.
+type Case struct {
+	Program  string // Path of the .br file
+	Name     string
+	Input    []byte
+	Expected []byte // nil when output is not checked
+}
+
+func (c Case) String() string {
+	return c.Program + " [" + c.Name + "]"
+}
+
+type Result struct {
+	Case
+	Output   []byte
+	Err      error // Compile or runtime failure
+	Steps    int
+	Duration time.Duration
+}
+
+func (r Result) Passed() bool {
+	return r.Err == nil && (r.Case.Expected == nil || bytes.Equal(r.Output, r.Case.Expected))
+}
+
+type Options struct {
+	Defines  []string
+	Target   *IR.Target
+	OptLevel int
+	MaxSteps int // Per case; 0 means MAX_STEPS
+	Jobs     int // Cases run at once; 0 means one per CPU
+}
+
+// ----------------------------------------------------
+// Discovery
+// ----------------------------------------------------
+
+// Discover finds the cases under paths. Directories are searched recursively,
+// skipping hidden ones; a .br file named directly is always a test.
+func Discover(paths []string) ([]Case, error) {
+	return find(paths, func(path string) (string, bool) {
+		switch {
+		case strings.HasSuffix(path, TEST_SUFFIX):
+			return path, true
+		case strings.HasSuffix(path, INPUT_EXT), strings.HasSuffix(path, OUTPUT_EXT):
+			program, _, ok := owner(path)
+			return program, ok
+		}
+		return "", false
+	})
+}
+
+// Corpus finds every program under paths, with the cases of those that have
+// case files
+func Corpus(paths []string) ([]Case, error) {
+	return find(paths, func(path string) (string, bool) {
+		return path, strings.HasSuffix(path, PROGRAM_EXT)
+	})
+}
+
+// find collects the cases of the programs that program picks out of the files
+// under paths
+func find(paths []string, program func(path string) (string, bool)) ([]Case, error) {
+	programs := make(map[string]bool)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			programs[path] = true
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if p != path && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if found, ok := program(p); ok {
+				programs[found] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cases := []Case{}
+	for program := range programs {
+		found, err := programCases(program)
+		if err != nil {
+			return nil, err
+		}
+		cases = append(cases, found...)
+	}
+	sort.Slice(cases, func(i, j int) bool {
+		if cases[i].Program != cases[j].Program {
+			return cases[i].Program < cases[j].Program
+		}
+		return cases[i].Name < cases[j].Name
+	})
+	return cases, nil
+}
+
+// owner returns the program and case a case file belongs to. name.out is the
+// default case of name.br when that exists, otherwise case out of name.
+func owner(path string) (program, name string, ok bool) {
+	base := strings.TrimSuffix(strings.TrimSuffix(path, INPUT_EXT), OUTPUT_EXT)
+	if exists(base + PROGRAM_EXT) {
+		return base + PROGRAM_EXT, DEFAULT_CASE, true
+	}
+	i := strings.LastIndexByte(base, '.')
+	if i <= len(filepath.Dir(base)) || !exists(base[:i]+PROGRAM_EXT) {
+		return "", "", false
+	}
+	return base[:i] + PROGRAM_EXT, base[i+1:], true
+}
+
+func exists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// programCases reads the case files of a program
+func programCases(program string) ([]Case, error) {
+	base := strings.TrimSuffix(program, PROGRAM_EXT)
+	files, err := filepath.Glob(escapeGlob(base) + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*Case)
+	names := []string{}
+	for _, file := range files {
+		if !strings.HasSuffix(file, INPUT_EXT) && !strings.HasSuffix(file, OUTPUT_EXT) {
+			continue
+		}
+		p, name, ok := owner(file)
+		if !ok || p != program {
+			continue
+		}
+		c := byName[name]
+		if c == nil {
+			c = &Case{Program: program, Name: name, Input: []byte{}}
+			byName[name] = c
+			names = append(names, name)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(file, INPUT_EXT) {
+			c.Input = data
+		} else {
+			c.Expected = data
+		}
+	}
+
+	if len(names) == 0 {
+		return []Case{{Program: program, Name: DEFAULT_CASE, Input: []byte{}}}, nil
+	}
+	cases := make([]Case, len(names))
+	for i, name := range names {
+		cases[i] = *byName[name]
+	}
+	return cases, nil
+}
+
+func escapeGlob(path string) string {
+	return strings.NewReplacer(`*`, `\*`, `?`, `\?`, `[`, `\[`, `\`, `\\`).Replace(path)
+}
+
+// ----------------------------------------------------
+// Running
+// ----------------------------------------------------
+
+// Run compiles every program once and runs the cases, spreading both over
+// opts.Jobs workers. Results are in the order of cases.
+func Run(cases []Case, opts *Options) []Result {
+	if opts == nil {
+		opts = &Options{}
+	}
+	programs := []string{}
+	compiled := make(map[string]*compiledProgram)
+	for _, c := range cases {
+		if compiled[c.Program] == nil {
+			compiled[c.Program] = &compiledProgram{}
+			programs = append(programs, c.Program)
+		}
+	}
+	parallel(jobs(opts), len(programs), func(i int) {
+		p := compiled[programs[i]]
+		p.program, p.err = compileFile(programs[i], opts)
+	})
+
+	results := make([]Result, len(cases))
+	parallel(jobs(opts), len(cases), func(i int) {
+		results[i] = runCase(cases[i], compiled[cases[i].Program], opts)
+	})
+	return results
+}
+
+type compiledProgram struct {
+	program *Interpreter.Program
+	err     error
+}
+
+func jobs(opts *Options) int {
+	if opts.Jobs <= 0 {
+		return runtime.NumCPU()
+	}
+	return opts.Jobs
+}
+
+// parallel calls f for 0..n-1 on up to jobs goroutines and waits for them
+func parallel(jobs, n int, f func(i int)) {
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(jobs, n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				f(i)
+			}
+		}()
+	}
+	for i := range n {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+func compileFile(path string, opts *Options) (*Interpreter.Program, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	_, program, err := compileSource(string(src), opts)
+	return program, err
+}
+
+// compileSource returns the syntax tree of a program along with its code
+func compileSource(src string, opts *Options) (AST.Ast, *Interpreter.Program, error) {
+	var a AST.Ast
+	var c *Compiler.Compiler
+	err := Logging.Catch(func() {
+		p := Parser.NewParser(src, Logging.NewRecoverableLogger("braining_parser"))
+		for _, name := range opts.Defines {
+			p.Define(name)
+		}
+		a = p.Parse()
+		c = Compiler.NewCompiler(a, opts.Target, Logging.NewRecoverableLogger("braining_compiler"))
+		c.OptLevel = opts.OptLevel
+		c.Compile()
+	})
+	if err != nil {
+		return a, nil, err
+	}
+	program, err := Interpreter.Compile(c.Code)
+	return a, program, err
+}
+
+// interpreterConfig is the configuration cases run with
+func interpreterConfig(opts *Options) Interpreter.Config {
+	config := Interpreter.DefaultConfig()
+	if opts.Target != nil {
+		config.CellBits = opts.Target.CellBits
+		config.Wrapping = opts.Target.Wrapping
+		config.Signed = opts.Target.AllowNegative
+	}
+	config.MaxSteps = opts.MaxSteps
+	if config.MaxSteps <= 0 {
+		config.MaxSteps = MAX_STEPS
+	}
+	return config
+}
+
+func runCase(c Case, p *compiledProgram, opts *Options) Result {
+	res := Result{Case: c, Output: []byte{}}
+	if p.err != nil {
+		res.Err = p.err
+		return res
+	}
+
+	out := &bytes.Buffer{}
+	m := Interpreter.NewMachine(p.program, interpreterConfig(opts), bytes.NewReader(c.Input), out)
+	start := time.Now()
+	res.Err = m.Run()
+	res.Duration = time.Since(start)
+	res.Output = out.Bytes()
+	res.Steps = m.Steps
+	return res
+}
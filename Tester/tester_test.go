@@ -0,0 +1,99 @@
+package Tester_test
+
+import (
+	"braining/Tester"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// corpus writes files into a fresh directory and returns it
+func corpus(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+const ECHO = "c = 0\nread c\nwrite c\nnl = 10\nwrite nl\n"
+
+func TestRun(t *testing.T) {
+	dir := corpus(t, map[string]string{
+		"pass.br":       ECHO,
+		"pass.in":       "a",
+		"pass.out":      "a\n",
+		"fail.br":       ECHO,
+		"fail.in":       "a",
+		"fail.out":      "b\n",
+		"named.br":      ECHO,
+		"named.x.in":    "x",
+		"named.x.out":   "x\n",
+		"named.y.in":    "y",
+		"named.y.out":   "z\n",
+		"smoke_test.br": ECHO,
+		"broken.br":     "write q\n",
+		"other.br":      ECHO,
+	})
+	cases, err := Tester.Corpus([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		program string
+		name    string
+		passed  bool
+		failed  bool // Compiling or running failed
+	}{
+		{"broken.br", "default", false, true},
+		{"fail.br", "default", false, false},
+		{"named.br", "x", true, false},
+		{"named.br", "y", false, false},
+		{"other.br", "default", true, false},
+		{"pass.br", "default", true, false},
+		{"smoke_test.br", "default", true, false},
+	}
+	results := Tester.Run(cases, &Tester.Options{Jobs: 2})
+	if len(results) != len(tests) {
+		t.Fatalf("got %d results, want %d", len(results), len(tests))
+	}
+	for i, tt := range tests {
+		r := results[i]
+		if r.Program != filepath.Join(dir, tt.program) || r.Name != tt.name {
+			t.Errorf("result %d is %s, want %s [%s]", i, r.Case, tt.program, tt.name)
+			continue
+		}
+		if r.Passed() != tt.passed || (r.Err != nil) != tt.failed {
+			t.Errorf("%s: passed %v, error %v", r.Case, r.Passed(), r.Err)
+		}
+	}
+
+	fail := results[1]
+	if got, want := Tester.Diff(fail.Expected, fail.Output), "- b\n+ a\n"; got != want {
+		t.Errorf("diff %q, want %q", got, want)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	dir := corpus(t, map[string]string{
+		"pass.br":       ECHO,
+		"pass.out":      "\n",
+		"smoke_test.br": ECHO,
+		"lib.br":        ECHO,
+		"stray.out":     "",
+	})
+	cases, err := Tester.Discover([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, c := range cases {
+		got = append(got, filepath.Base(c.Program))
+	}
+	if len(got) != 2 || got[0] != "pass.br" || got[1] != "smoke_test.br" {
+		t.Errorf("found %v, want pass.br and smoke_test.br", got)
+	}
+}
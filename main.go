@@ -1,26 +1,779 @@
 package main
 
 import (
+	"braining/AST"
+	"braining/Backend"
 	"braining/Compiler"
+	"braining/Debugger"
+	"braining/Formatter"
+	"braining/IR"
+	"braining/Interpreter"
+	"braining/LSP"
+	"braining/Lexer"
+	"braining/Logging"
 	"braining/Parser"
+	"braining/Repl"
+	"braining/Tester"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
 )
 
+// errUsage is returned for bad arguments once usage has been printed
+var errUsage = errors.New("usage")
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"build", "compile a program to Brainf***, C, Go or an ELF executable", buildCommand},
+		{"run", "compile a program and run it with the built-in interpreter", runCommand},
+		{"check", "report errors without writing any output", checkCommand},
+		{"cost", "report the Brainf*** each statement and macro call compiles to", costCommand},
+		{"test", "run *_test.br programs and programs with .in and .out case files", testCommand},
+		{"difftest", "compare compiled programs against the reference evaluator", difftestCommand},
+		{"ast", "print the syntax tree of a program", astCommand},
+		{"tokens", "print the tokens of a program", tokensCommand},
+		{"fmt", "print programs in the canonical layout", fmtCommand},
+		{"debug", "debug a program; commands are read from stdin", debugCommand},
+		{"repl", "evaluate statements interactively", replCommand},
+		{"lsp", "serve the Language Server Protocol over stdin and stdout", lspCommand},
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: braining COMMAND [flags] [FILE]")
+	fmt.Fprintln(os.Stderr, "FILE - or no FILE reads the program from stdin.")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun braining COMMAND -h for the flags of a command.")
+}
+
 func main() {
-	f, err := os.ReadFile("test2.br")
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		usage()
+		return
+	}
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		err := cmd.run(os.Args[2:])
+		switch {
+		case err == nil:
+		case errors.Is(err, flag.ErrHelp):
+		case errors.Is(err, errUsage):
+			os.Exit(2)
+		default:
+			fmt.Fprintln(os.Stderr, "braining: "+err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintln(os.Stderr, "braining: unknown command "+name)
+	usage()
+	os.Exit(2)
+}
+
+// ----------------------------------------------------
+// Flags
+// ----------------------------------------------------
+
+// defineFlags collects repeated -D NAME flags
+type defineFlags []string
+
+func (d *defineFlags) String() string {
+	return strings.Join(*d, ",")
+}
+
+func (d *defineFlags) Set(name string) error {
+	*d = append(*d, name)
+	return nil
+}
+
+// options are the flags shared by every command that compiles
+type options struct {
+	defines  defineFlags
+	target   *IR.Target
+	optLevel int
+}
+
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: braining %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func addDefineFlag(fs *flag.FlagSet, opts *options) {
+	fs.Var(&opts.defines, "D", "enable #if regions for `NAME` (repeatable)")
+}
+
+func addCompileFlags(fs *flag.FlagSet) *options {
+	opts := &options{target: IR.DefaultTarget()}
+	addDefineFlag(fs, opts)
+	fs.IntVar(&opts.target.CellBits, "cell-bits", opts.target.CellBits, "cell width of the target interpreter (8, 16 or 32)")
+	fs.BoolVar(&opts.target.Wrapping, "wrap", opts.target.Wrapping, "target cells wrap around on overflow")
+	fs.BoolVar(&opts.target.AllowNegative, "signed", opts.target.AllowNegative, "target cells may hold negative values")
+	fs.IntVar(&opts.optLevel, "O", IR.O_NONE, "optimization level (0-2)")
+	return opts
+}
+
+// parseFlags parses args, turning flag errors into errUsage since the flag
+// set has already reported them
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		return errUsage
+	}
+	return err
+}
+
+// ----------------------------------------------------
+// Loading and compiling
+// ----------------------------------------------------
+
+// sourceArg returns the single FILE argument, or "-" for stdin
+func sourceArg(fs *flag.FlagSet) (string, error) {
+	switch fs.NArg() {
+	case 0:
+		return "-", nil
+	case 1:
+		return fs.Arg(0), nil
+	}
+	fs.Usage()
+	return "", errUsage
+}
+
+func readSource(path string) (string, error) {
+	if path == "-" {
+		src, err := io.ReadAll(os.Stdin)
+		return string(src), err
+	}
+	src, err := os.ReadFile(path)
+	return string(src), err
+}
+
+// displayName is how errors refer to a source path
+func displayName(path string) string {
+	if path == "-" {
+		return "<stdin>"
+	}
+	return path
+}
+
+// located prefixes err with the source path and, when known, its position
+func located(path string, err error) error {
+	var srcErr *Logging.SourceError
+	if errors.As(err, &srcErr) {
+		return fmt.Errorf("%s:%d:%d: %w", displayName(path), srcErr.Line, srcErr.Col, err)
+	}
+	return fmt.Errorf("%s: %w", displayName(path), err)
+}
+
+func parse(path, src string, opts *options) (AST.Ast, error) {
+	var a AST.Ast
+	err := Logging.Catch(func() {
+		p := Parser.NewParser(src, Logging.NewRecoverableLogger("braining_parser"))
+		for _, name := range opts.defines {
+			p.Define(name)
+		}
+		a = p.Parse()
+	})
+	if err != nil {
+		return a, located(path, err)
+	}
+	return a, nil
+}
+
+func newCompiler(path, src string, a AST.Ast, opts *options) (*Compiler.Compiler, error) {
+	var c *Compiler.Compiler
+	err := Logging.Catch(func() {
+		c = Compiler.NewCompiler(a, opts.target, Logging.NewRecoverableLogger("braining_compiler"))
+	})
+	if err != nil {
+		return nil, located(path, err)
+	}
+	c.OptLevel = opts.optLevel
+	c.Source = src
+	return c, nil
+}
+
+// compile runs f, which drives the compiler, and turns compile errors into
+// errors naming the source
+func compile(path string, f func() error) error {
+	var err error
+	if caught := Logging.Catch(func() { err = f() }); caught != nil {
+		err = caught
+	}
+	if err != nil {
+		return located(path, err)
+	}
+	return nil
+}
+
+// load reads, parses and prepares a compiler for the program named by fs, and
+// returns its path
+func load(fs *flag.FlagSet, opts *options) (*Compiler.Compiler, string, error) {
+	path, err := sourceArg(fs)
 	if err != nil {
-		panic(err)
+		return nil, "", err
 	}
+	src, err := readSource(path)
+	if err != nil {
+		return nil, "", err
+	}
+	a, err := parse(path, src, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := newCompiler(path, src, a, opts)
+	return c, path, err
+}
+
+func interpreterConfig(target *IR.Target) Interpreter.Config {
+	config := Interpreter.DefaultConfig()
+	config.CellBits = target.CellBits
+	config.Wrapping = target.Wrapping
+	config.Signed = target.AllowNegative
+	return config
+}
+
+// ----------------------------------------------------
+// Commands
+// ----------------------------------------------------
 
-	src := string(f)
+func buildCommand(args []string) error {
+	fs := newFlagSet("build", "[flags] [FILE]")
+	opts := addCompileFlags(fs)
+	backend := fs.String("backend", "bf", "output to produce: bf, c, go or elf")
+	output := fs.String("o", "", "output file, - for stdout (default FILE with the extension of the backend, or stdout when reading stdin)")
+	goPackage := fs.String("go-package", "main", "package name for the go backend")
+	dialect := fs.String("dialect", "bf", "alphabet of the bf backend: bf, ook or blub")
+	dialectTokens := fs.String("dialect-tokens", "", "custom alphabet for the bf backend: space separated `inc dec left right write read open close [breakpoint]`")
+	annotate := fs.Bool("annotate", false, "lay the bf output out per statement with the source as comments")
+	dumpIR := fs.Bool("dump-ir", false, "print the IR instead of compiling")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
 
-	p := Parser.NewParser(src, nil)
-	a := p.Parse()
+	c, path, err := load(fs, opts)
+	if err != nil {
+		return err
+	}
+	c.Annotate = *annotate
+	if *dialectTokens != "" {
+		if c.Dialect, err = Backend.NewDialect(strings.Fields(*dialectTokens)); err != nil {
+			return err
+		}
+	} else if d, ok := Backend.Dialects[*dialect]; ok {
+		c.Dialect = d
+	} else {
+		return errors.New("unknown dialect " + *dialect)
+	}
 
+	if *dumpIR {
+		return compile(path, func() error { return c.DumpIR(os.Stdout) })
+	}
+
+	var sink func(io.Writer) IR.Sink
+	ext := ".b"
+	switch *backend {
+	case "bf":
+	case "c":
+		ext = ".c"
+		sink = func(w io.Writer) IR.Sink { return Backend.NewCEmitter(w, opts.target) }
+	case "go":
+		ext = filepath.Join("_go", "main.go")
+		sink = func(w io.Writer) IR.Sink { return Backend.NewGoEmitter(w, opts.target, *goPackage) }
+	case "elf":
+		ext = ".elf"
+		sink = func(w io.Writer) IR.Sink { return Backend.NewELFEmitter(w, opts.target) }
+	default:
+		return errors.New("unknown backend " + *backend)
+	}
+
+	out := *output
+	if out == "" && path != "-" {
+		out = strings.TrimSuffix(path, filepath.Ext(path)) + ext
+	}
+	if out == "" || out == "-" {
+		if sink == nil {
+			return compile(path, func() error { return c.CompileTo(os.Stdout) })
+		}
+		return compile(path, func() error { return c.CompileIR(sink(os.Stdout)) })
+	}
+
+	if sink == nil {
+		return compile(path, func() error {
+			c.WriteToFile(out)
+			return nil
+		})
+	}
+	perm := os.FileMode(0644)
+	if *backend == "elf" {
+		perm = 0755
+	}
+	return writeBackend(path, c, out, perm, sink)
+}
+
+// writeBackend compiles into a file through one of the non-Brainf*** backends
+func writeBackend(path string, c *Compiler.Compiler, out string, perm os.FileMode, backend func(io.Writer) IR.Sink) error {
+	dir := filepath.Dir(out)
+	_, statErr := os.Stat(dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	err := compile(path, func() error {
+		return Compiler.WriteFile(out, perm, func(w io.Writer) error {
+			return c.CompileIR(backend(w))
+		})
+	})
+	if err != nil && os.IsNotExist(statErr) {
+		os.Remove(dir) // Only made for the output
+	}
+	return err
+}
+
+func runCommand(args []string) error {
+	fs := newFlagSet("run", "[flags] [FILE]")
+	opts := addCompileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	c, path, err := load(fs, opts)
+	if err != nil {
+		return err
+	}
+	c.CollectCode = true
+	if err := compile(path, func() error { return c.CompileTo(nil) }); err != nil {
+		return err
+	}
+
+	program, err := Interpreter.Compile(c.Code)
+	if err != nil {
+		return err
+	}
+	return Interpreter.NewMachine(program, interpreterConfig(opts.target), os.Stdin, os.Stdout).Run()
+}
+
+func checkCommand(args []string) error {
+	fs := newFlagSet("check", "[flags] [FILE...]")
+	opts := addCompileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+
+	failed := 0
+	for _, path := range paths {
+		err := checkFile(path, opts)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed", failed, len(paths))
+	}
+	return nil
+}
+
+func checkFile(path string, opts *options) error {
+	src, err := readSource(path)
+	if err != nil {
+		return err
+	}
+	a, err := parse(path, src, opts)
+	if err != nil {
+		return err
+	}
+	c, err := newCompiler(path, src, a, opts)
+	if err != nil {
+		return err
+	}
+	return compile(path, func() error { return c.CompileIR(IR.NewDumper(io.Discard)) })
+}
+
+func costCommand(args []string) error {
+	fs := newFlagSet("cost", "[flags] [FILE]")
+	opts := addCompileFlags(fs)
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	c, path, err := load(fs, opts)
+	if err != nil {
+		return err
+	}
+	if err := compile(path, func() error { return c.CompileTo(io.Discard) }); err != nil {
+		return err
+	}
+	if *asJSON {
+		return c.Costs.Write(os.Stdout)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	row := func(where string, cost Compiler.Cost, text string) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t  %s\n", where, cost.Instructions, cost.Moves, cost.Temps, text)
+	}
+	fmt.Fprintln(w, "LINE:COL\tINSTRUCTIONS\tMOVES\tTEMPS\t  SOURCE")
+	for _, e := range c.Costs.Statements {
+		row(fmt.Sprintf("%d:%d", e.Line, e.Col), e.Cost, e.Text)
+	}
+	if len(c.Costs.Expansions) > 0 {
+		fmt.Fprintln(w, "\t\t\t\t")
+		fmt.Fprintln(w, "EXPANSION\t\t\t\t")
+		for _, e := range c.Costs.Expansions {
+			row(fmt.Sprintf("%d:%d", e.Line, e.Col), e.Cost, e.Text)
+		}
+	}
+	fmt.Fprintln(w, "\t\t\t\t")
+	row("total", c.Costs.Total, "")
+	return w.Flush()
+}
+
+func testCommand(args []string) error {
+	fs := newFlagSet("test", "[flags] [PATH...]")
+	opts := addCompileFlags(fs)
+	steps := fs.Int("steps", Tester.MAX_STEPS, "instructions each case may execute")
+	jobs := fs.Int("j", runtime.NumCPU(), "cases to run at once")
+	verbose := fs.Bool("v", false, "list passing cases as well")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	cases, err := Tester.Discover(paths)
+	if err != nil {
+		return err
+	}
+	if len(cases) == 0 {
+		return errors.New("no tests found")
+	}
+	results := Tester.Run(cases, &Tester.Options{
+		Defines:  opts.defines,
+		Target:   opts.target,
+		OptLevel: opts.optLevel,
+		MaxSteps: *steps,
+		Jobs:     *jobs,
+	})
+
+	failed := 0
+	for _, r := range results {
+		duration := r.Duration.Round(time.Millisecond)
+		if r.Passed() {
+			if *verbose {
+				fmt.Printf("ok   %s (%d steps, %s)\n", r.Case, r.Steps, duration)
+			}
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL %s (%d steps, %s)\n", r.Case, r.Steps, duration)
+		if r.Err != nil {
+			fmt.Println("    " + located(r.Program, r.Err).Error())
+			continue
+		}
+		fmt.Println("    output differs (- expected, + actual):")
+		for _, line := range strings.SplitAfter(strings.TrimSuffix(Tester.Diff(r.Expected, r.Output), "\n"), "\n") {
+			fmt.Print("    " + line)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("%d passed, %d failed\n", len(results)-failed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d cases failed", failed, len(results))
+	}
+	return nil
+}
+
+func difftestCommand(args []string) error {
+	fs := newFlagSet("difftest", "[flags] [PATH...]")
+	opts := addCompileFlags(fs)
+	n := fs.Int("n", 1000, "random programs to generate when no PATH is given")
+	seed := fs.Uint64("seed", 0, "seed for the random programs; 0 picks one from the clock")
+	first := fs.Int("first", 0, "number of the first random program, to generate one again")
+	steps := fs.Int("steps", Tester.MAX_STEPS, "instructions or statements each run may execute")
+	jobs := fs.Int("j", runtime.NumCPU(), "programs to run at once")
+	shrink := fs.Bool("shrink", true, "cut diverging random programs down before showing them")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	testOpts := &Tester.Options{
+		Defines:  opts.defines,
+		Target:   opts.target,
+		OptLevel: opts.optLevel,
+		MaxSteps: *steps,
+		Jobs:     *jobs,
+	}
+	var results []Tester.Comparison
+	if fs.NArg() == 0 {
+		if *seed == 0 {
+			*seed = uint64(time.Now().UnixNano())
+		}
+		fmt.Printf("seed %d\n", *seed)
+		results = Tester.CompareRandom(*seed, *first, *n, testOpts)
+	} else {
+		cases, err := Tester.Corpus(fs.Args())
+		if err != nil {
+			return err
+		}
+		results = Tester.CompareCases(cases, testOpts)
+	}
+
+	diverged, inconclusive, skipped := 0, 0, 0
+	for i, r := range results {
+		switch {
+		case r.Skipped != nil:
+			skipped++
+			fmt.Printf("skip %s: %s\n", r.Name, r.Skipped)
+		case !r.Diverged() && r.Inconclusive():
+			inconclusive++
+			fmt.Printf("inconclusive %s: out of steps\n", r.Name)
+		case r.Diverged():
+			diverged++
+			fmt.Printf("DIVERGED %s\n", r.Name)
+			if fs.NArg() == 0 {
+				if *shrink {
+					r = Tester.Shrink(r, testOpts)
+				}
+				for _, line := range strings.SplitAfter(strings.TrimSuffix(r.Source, "\n"), "\n") {
+					fmt.Print("    | " + line)
+				}
+				fmt.Printf("\n    input %q\n", r.Input)
+				fmt.Printf("    generate it again with -seed %d -first %d -n 1\n", *seed, *first+i)
+			}
+			if r.EvalErr != nil {
+				fmt.Println("    evaluator: " + r.EvalErr.Error())
+			}
+			if r.RunErr != nil {
+				fmt.Println("    compiled: " + r.RunErr.Error())
+			}
+			fmt.Println("    output (- evaluator, + compiled):")
+			for _, line := range strings.SplitAfter(strings.TrimSuffix(Tester.Diff(r.Expected, r.Output), "\n"), "\n") {
+				fmt.Print("    " + line)
+			}
+			fmt.Println()
+		}
+	}
+	fmt.Printf("%d agreed, %d diverged, %d inconclusive, %d skipped\n",
+		len(results)-diverged-inconclusive-skipped, diverged, inconclusive, skipped)
+	if diverged > 0 {
+		return fmt.Errorf("%d of %d programs diverged", diverged, len(results))
+	}
+	return nil
+}
+
+func astCommand(args []string) error {
+	fs := newFlagSet("ast", "[flags] [FILE]")
+	opts := &options{}
+	addDefineFlag(fs, opts)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	path, err := sourceArg(fs)
+	if err != nil {
+		return err
+	}
+	src, err := readSource(path)
+	if err != nil {
+		return err
+	}
+	a, err := parse(path, src, opts)
+	if err != nil {
+		return err
+	}
 	a.Display()
+	return nil
+}
+
+func tokensCommand(args []string) error {
+	fs := newFlagSet("tokens", "[flags] [FILE]")
+	opts := &options{}
+	addDefineFlag(fs, opts)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	path, err := sourceArg(fs)
+	if err != nil {
+		return err
+	}
+	src, err := readSource(path)
+	if err != nil {
+		return err
+	}
+
+	l := Lexer.NewLexer(src, Logging.NewRecoverableLogger("braining_lexer"))
+	for _, name := range opts.defines {
+		l.Define(name)
+	}
+	err = Logging.Catch(func() {
+		asm := false
+		for {
+			t := l.Advance()
+			fmt.Printf("%d:%d\t%s\t%q\n", t.Line, t.Col, t.Type, t.Value)
+			switch t.Type {
+			case Lexer.T_DONE:
+				return
+			case Lexer.T_ASM:
+				asm = true
+			case Lexer.T_MACRO_DEFINE:
+				// An asm body is raw text rather than tokens
+				if asm {
+					body, _ := l.ReadRaw(Lexer.ASM_END_KEYWORD)
+					fmt.Printf("\tASM_BODY\t%q\n", body)
+					asm = false
+				}
+			}
+		}
+	})
+	if err != nil {
+		return located(path, err)
+	}
+	return nil
+}
+
+func fmtCommand(args []string) error {
+	fs := newFlagSet("fmt", "[flags] [FILE...]")
+	write := fs.Bool("w", false, "write the result back to each file instead of printing it")
+	check := fs.Bool("check", false, "list files that are not formatted and fail if there are any")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+
+	unformatted := 0
+	for _, path := range paths {
+		src, err := readSource(path)
+		if err != nil {
+			return err
+		}
+		formatted, err := Formatter.Format(src)
+		if err != nil {
+			return located(path, err)
+		}
+		switch {
+		case *check:
+			if formatted != src {
+				fmt.Println(displayName(path))
+				unformatted++
+			}
+		case *write && path != "-":
+			if formatted != src {
+				if err := os.WriteFile(path, []byte(formatted), 0644); err != nil {
+					return err
+				}
+			}
+		default:
+			fmt.Print(formatted)
+		}
+	}
+	if unformatted > 0 {
+		return fmt.Errorf("%d of %d files are not formatted", unformatted, len(paths))
+	}
+	return nil
+}
+
+func debugCommand(args []string) error {
+	fs := newFlagSet("debug", "[flags] FILE")
+	opts := addCompileFlags(fs)
+	input := fs.String("input", "", "file the program reads its input from")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || fs.Arg(0) == "-" {
+		// Commands come from stdin, so the program cannot
+		fs.Usage()
+		return errUsage
+	}
+	c, path, err := load(fs, opts)
+	if err != nil {
+		return err
+	}
+	c.CollectCode = true
+	if err := compile(path, func() error { return c.CompileTo(nil) }); err != nil {
+		return err
+	}
 
-	c := Compiler.NewCompiler(a, nil)
-	c.Compile()
+	var in io.Reader = strings.NewReader("")
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
 
-	c.WriteToFile("test.b")
+	d, err := Debugger.NewDebugger(c.Code, c.SourceMap, c.Source, interpreterConfig(opts.target), in, os.Stdout)
+	if err != nil {
+		return err
+	}
+	return d.Run(os.Stdin, os.Stdout)
+}
+
+func replCommand(args []string) error {
+	fs := newFlagSet("repl", "[flags]")
+	opts := addCompileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errUsage
+	}
+	r, err := Repl.NewRepl(opts.target, opts.optLevel, os.Stdin, os.Stdout)
+	if err != nil {
+		return err
+	}
+	for _, name := range opts.defines {
+		r.Define(name)
+	}
+	return r.Run()
+}
+
+func lspCommand(args []string) error {
+	fs := newFlagSet("lsp", "[flags]")
+	opts := &options{}
+	addDefineFlag(fs, opts)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	s := LSP.NewServer(os.Stdin, os.Stdout)
+	for _, name := range opts.defines {
+		s.Define(name)
+	}
+	return s.Run()
 }